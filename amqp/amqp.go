@@ -0,0 +1,232 @@
+package amqp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultPrefetch      = 16
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = 5 * time.Second
+	defaultMaxRetryDelay = 15 * time.Minute
+
+	consumerTag = "octostats"
+	eventHeader = "X-GitHub-Event"
+)
+
+type Config struct {
+	URL   string `json:"url"`
+	Queue string `json:"queue"`
+
+	// Prefetch is the number of unacknowledged messages handled
+	// concurrently.
+	Prefetch int `json:"prefetch"`
+
+	// Messages which fail to be handled are retried with an exponential
+	// backoff from RetryDelay, up to MaxAttempts. Undecodable messages, and
+	// those which exhausted their attempts, are rejected: they go to the
+	// dead-letter exchange of the queue if it has one, or are dropped.
+	MaxAttempts   int    `json:"max_attempts"`
+	RetryDelay    string `json:"retry_delay"`
+	MaxRetryDelay string `json:"max_retry_delay"`
+}
+
+type session struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	deliveries <-chan amqp.Delivery
+}
+
+// Consumer reads events from an AMQP queue, such as a RabbitMQ one. Messages
+// are only acknowledged once handled or given up on, and the connection is
+// reestablished when lost.
+type Consumer struct {
+	retrier  delivery.Retrier
+	url      string
+	queue    string
+	prefetch int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(config *Config, handler delivery.Handler) (*Consumer, error) {
+	if config.URL == "" || config.Queue == "" {
+		return nil, fmt.Errorf("missing AMQP url or queue")
+	}
+
+	c := newConsumer(handler)
+	c.url, c.queue = config.URL, config.Queue
+	if config.Prefetch > 0 {
+		c.prefetch = config.Prefetch
+	}
+	if config.MaxAttempts > 0 {
+		c.retrier.MaxAttempts = config.MaxAttempts
+	}
+	var err error
+	if config.RetryDelay != "" {
+		if c.retrier.Delay, err = time.ParseDuration(config.RetryDelay); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxRetryDelay != "" {
+		if c.retrier.MaxDelay, err = time.ParseDuration(config.MaxRetryDelay); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fail early if the broker can't be reached.
+	s, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	go c.run(s)
+	return c, nil
+}
+
+func newConsumer(handler delivery.Handler) *Consumer {
+	return &Consumer{
+		retrier: delivery.Retrier{
+			Handler:     handler,
+			MaxAttempts: defaultMaxAttempts,
+			Delay:       defaultRetryDelay,
+			MaxDelay:    defaultMaxRetryDelay,
+		},
+		prefetch: defaultPrefetch,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Stop stops consuming messages, once those being handled are, and closes
+// the connection.
+func (c *Consumer) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *Consumer) connect() (*session, error) {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := conn.Channel()
+	if err == nil {
+		err = channel.Qos(c.prefetch, 0, false)
+	}
+	var deliveries <-chan amqp.Delivery
+	if err == nil {
+		deliveries, err = channel.Consume(c.queue, consumerTag, false, false, false, false, nil)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &session{conn: conn, channel: channel, deliveries: deliveries}, nil
+}
+
+func (c *Consumer) run(s *session) {
+	defer close(c.done)
+	for {
+		c.consume(s)
+		s.conn.Close()
+
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		log.Logger.Warn("AMQP connection lost: reconnecting")
+		if s = c.reconnect(); s == nil {
+			return
+		}
+	}
+}
+
+// consume handles the deliveries of the session until the connection is
+// lost, or the consumer is stopped.
+func (c *Consumer) consume(s *session) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.prefetch; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range s.deliveries {
+				c.handle(d)
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-c.stop:
+		// Cancelling the consumer closes the deliveries channel once those
+		// already received are handled.
+		if err := s.channel.Cancel(consumerTag, false); err != nil {
+			log.Logger.Errorf("Failed to cancel AMQP consumer: %v", err)
+			s.conn.Close()
+		}
+		<-finished
+	}
+}
+
+func (c *Consumer) reconnect() *session {
+	for attempt := 1; delivery.Sleep(c.retrier.Backoff(attempt), c.stop); attempt++ {
+		s, err := c.connect()
+		if err == nil {
+			log.Logger.Info("AMQP connection reestablished")
+			return s
+		}
+		log.Logger.Errorf("AMQP reconnection failed: %v", err)
+	}
+	return nil
+}
+
+// handle passes the delivery to the consumer handler, retrying it with a
+// backoff on failure. Retrying is pointless for some errors, and stops after
+// the maximum number of attempts, in which case the message is rejected. The
+// message is requeued if the consumer is stopped first.
+func (c *Consumer) handle(d amqp.Delivery) {
+	fields := map[string]interface{}{"delivery": d.DeliveryTag}
+	log.Logger.WithFields(fields).Debug("Queue event received")
+
+	switch err := c.retrier.Handle(eventType(d), d.Body, c.stop, fields); err {
+	case nil:
+		settle(d, d.Ack(false))
+	case delivery.ErrStopped:
+		settle(d, d.Nack(false, true))
+	default:
+		log.Logger.WithFields(fields).Errorf("Giving up on message: %v", err)
+		settle(d, d.Reject(false))
+	}
+}
+
+func settle(d amqp.Delivery, err error) {
+	if err != nil {
+		log.Logger.WithField("delivery", d.DeliveryTag).Errorf("Failed to acknowledge message: %v", err)
+	}
+}
+
+// eventType returns the type of event of the delivery, if the publisher set
+// it as the message type or forwarded it in the headers.
+func eventType(d amqp.Delivery) string {
+	if d.Type != "" {
+		return d.Type
+	}
+	if event, ok := d.Headers[eventHeader].(string); ok {
+		return event
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package amqp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/delivery"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "bad payload" }
+func (permanentError) Permanent() bool { return true }
+
+type recordingHandler struct {
+	errs   []error
+	events []string
+}
+
+func (h *recordingHandler) HandleEvent(event string, payload []byte) error {
+	h.events = append(h.events, event)
+	if len(h.errs) == 0 {
+		return nil
+	}
+	err := h.errs[0]
+	h.errs = h.errs[1:]
+	return err
+}
+
+type recordingAcknowledger struct {
+	acked, rejected, requeued bool
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.requeued = requeue
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejected = !requeue
+	return nil
+}
+
+func testConsumer(h delivery.Handler) *Consumer {
+	c := newConsumer(h)
+	c.retrier.MaxAttempts = 3
+	c.retrier.Delay = time.Millisecond
+	c.retrier.MaxDelay = 2 * time.Millisecond
+	return c
+}
+
+func handle(c *Consumer, d amqp.Delivery) *recordingAcknowledger {
+	a := &recordingAcknowledger{}
+	d.Acknowledger = a
+	c.handle(d)
+	return a
+}
+
+func TestHandleRetry(t *testing.T) {
+	h := &recordingHandler{errs: []error{errors.New("store unavailable")}}
+	c := testConsumer(h)
+
+	a := handle(c, amqp.Delivery{Type: "push", Body: []byte("{}")})
+	if !a.acked || a.rejected {
+		t.Fatal("Expected message to be acknowledged")
+	}
+	if len(h.events) != 2 || h.events[1] != "push" {
+		t.Fatalf("Expected 2 attempts of a push event but got %v\n", h.events)
+	}
+}
+
+func TestHandleReject(t *testing.T) {
+	for _, tc := range []struct {
+		errs     []error
+		attempts int
+	}{
+		{[]error{permanentError{}}, 1},
+		{[]error{errors.New("a"), errors.New("b"), errors.New("c")}, 3},
+	} {
+		h := &recordingHandler{errs: tc.errs}
+		a := handle(testConsumer(h), amqp.Delivery{Body: []byte("{}")})
+		if a.acked || !a.rejected {
+			t.Fatal("Expected message to be rejected")
+		}
+		if len(h.events) != tc.attempts {
+			t.Fatalf("Expected %d attempts but got %d\n", tc.attempts, len(h.events))
+		}
+	}
+}
+
+func TestHandleStopped(t *testing.T) {
+	c := testConsumer(&recordingHandler{errs: []error{errors.New("store unavailable")}})
+	close(c.stop)
+	if a := handle(c, amqp.Delivery{Body: []byte("{}")}); !a.requeued {
+		t.Fatal("Expected message to be requeued once stopped")
+	}
+}
+
+func TestEventType(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{"X-GitHub-Event": "issues"}}
+	if event := eventType(d); event != "issues" {
+		t.Fatalf("Expected event type from headers but got %q\n", event)
+	}
+}
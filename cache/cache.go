@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Cache persists the issues and pull requests payloads of repositories along
+// with their synchronization cursors, so that a restart doesn't require a
+// full crawl. Items are keyed by repository, kind (such as "issues" or
+// "pulls") and number.
+type Cache interface {
+	// Load returns all cached items of the given kind for a repository, and
+	// the last stored cursor (nil if none).
+	Load(nwo, kind string) (map[int]json.RawMessage, []byte, error)
+
+	// Store saves the given items, overwriting any previous version, along
+	// with the updated cursor.
+	Store(nwo, kind string, items map[int]json.RawMessage, cursor []byte) error
+
+	Close() error
+}
+
+type Config struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+func New(c *Config) (Cache, error) {
+	switch c.Type {
+	case "", "file":
+		return NewFile(c.Path)
+	default:
+		return nil, fmt.Errorf("invalid cache type '%s'", c.Type)
+	}
+}
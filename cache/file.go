@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/icecrime/octostats/journal"
+)
+
+const cursorKey = "cursor"
+
+// record is a single entry of the file cache log.
+type record struct {
+	Key   string          `json:"k"`
+	Value json.RawMessage `json:"v"`
+}
+
+// fileCache is an embedded key/value store backed by a journal of JSON
+// records. The journal is replayed in memory when opened, and compacted, when
+// opened or written to, once most of its records were overwritten.
+type fileCache struct {
+	journal *journal.Log
+	entries map[string]json.RawMessage
+	m       sync.Mutex
+}
+
+// NewFile opens, or creates, the file cache at the given path.
+func NewFile(path string) (Cache, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing path for file cache")
+	}
+
+	c := &fileCache{entries: make(map[string]json.RawMessage)}
+	l, err := journal.Open(path, func(b []byte) error {
+		var r record
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		c.entries[r.Key] = r.Value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.journal = l
+
+	if c.overwritten() {
+		if err := c.compact(); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// overwritten returns whether most of the log records were overwritten,
+// which makes it worth compacting.
+func (c *fileCache) overwritten() bool {
+	return c.journal.Records() > 2*len(c.entries)
+}
+
+func (c *fileCache) compact() error {
+	records := make([]interface{}, 0, len(c.entries))
+	for k, v := range c.entries {
+		records = append(records, record{Key: k, Value: v})
+	}
+	return c.journal.Compact(records)
+}
+
+func (c *fileCache) Load(nwo, kind string) (map[int]json.RawMessage, []byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	prefix := itemPrefix(nwo, kind)
+	items := make(map[int]json.RawMessage)
+	for k, v := range c.entries {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if number, err := strconv.Atoi(k[len(prefix):]); err == nil {
+			items[number] = v
+		}
+	}
+	return items, c.entries[itemPrefix(nwo, kind)+cursorKey], nil
+}
+
+func (c *fileCache) Store(nwo, kind string, items map[int]json.RawMessage, cursor []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	prefix := itemPrefix(nwo, kind)
+	records := make([]interface{}, 0, len(items)+1)
+	for number, v := range items {
+		records = append(records, record{Key: prefix + strconv.Itoa(number), Value: v})
+	}
+
+	// The cursor is written last so that it never gets ahead of the items.
+	if cursor != nil {
+		records = append(records, record{Key: prefix + cursorKey, Value: cursor})
+	}
+	if err := c.journal.Append(records...); err != nil {
+		return err
+	}
+	for _, r := range records {
+		r := r.(record)
+		c.entries[r.Key] = r.Value
+	}
+
+	if !c.overwritten() {
+		return nil
+	}
+	return c.compact()
+}
+
+func (c *fileCache) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.journal.Close()
+}
+
+func itemPrefix(nwo, kind string) string {
+	return fmt.Sprintf("%s/%s/", nwo, kind)
+}
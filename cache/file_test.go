@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFileCachePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octostats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "cache")
+
+	c, err := NewFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		items := map[int]json.RawMessage{1: json.RawMessage(`{"number":1}`), 2: json.RawMessage(`{"number":2}`)}
+		if err := c.Store("docker.docker", "issues", items, []byte(`"cursor"`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	if c, err = NewFile(p); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	items, cursor, err := c.Load("docker.docker", "issues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items but got %d\n", len(items))
+	}
+	if string(cursor) != `"cursor"` {
+		t.Fatalf("Unexpected cursor %s\n", cursor)
+	}
+	if records := c.(*fileCache).journal.Records(); records != 3 {
+		t.Fatalf("Expected compacted cache to hold 3 records but got %d\n", records)
+	}
+
+	if items, _, _ = c.Load("docker.docker", "pulls"); len(items) != 0 {
+		t.Fatalf("Expected no pull requests but got %d\n", len(items))
+	}
+}
+
+func TestFileCacheCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octostats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "cache")
+
+	c, err := NewFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	items := map[int]json.RawMessage{1: json.RawMessage(`{"number":1}`), 2: json.RawMessage(`{"number":2}`)}
+	for i := 0; i < 100; i++ {
+		if err := c.Store("docker.docker", "issues", items, []byte(`"cursor"`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if records := c.(*fileCache).journal.Records(); records > 6 {
+		t.Fatalf("Expected the cache to be compacted while open but it holds %d records\n", records)
+	}
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := len(strings.Split(strings.TrimSpace(string(content)), "\n")); lines > 6 {
+		t.Fatalf("Expected the log to be compacted but it holds %d lines\n", lines)
+	}
+}
@@ -4,13 +4,74 @@ import (
 	"encoding/json"
 	"io/ioutil"
 
+	"github.com/icecrime/octostats/amqp"
+	"github.com/icecrime/octostats/cache"
+	"github.com/icecrime/octostats/kafka"
 	"github.com/icecrime/octostats/nsq"
+	"github.com/icecrime/octostats/webhook"
 )
 
+const DefaultRefreshFrequency = "1h"
+
+type RepositoryConfig struct {
+	Name            string `json:"name"`
+	UpdateFrequency string `json:"update_frequency"`
+}
+
+// OrganizationConfig describes an organization (or user) whose repositories
+// are discovered through the GitHub API rather than listed explicitly.
+type OrganizationConfig struct {
+	Name             string   `json:"name"`
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	SkipArchived     bool     `json:"skip_archived"`
+	SkipForks        bool     `json:"skip_forks"`
+	RefreshFrequency string   `json:"refresh_frequency"`
+	UpdateFrequency  string   `json:"update_frequency"`
+}
+
+// GitHubAppConfig holds the credentials to authenticate as an installation
+// of a GitHub App.
+type GitHubAppConfig struct {
+	AppID          int64  `json:"app_id"`
+	PrivateKeyFile string `json:"private_key_file"`
+	InstallationID int64  `json:"installation_id"`
+}
+
 type GitHubConfig struct {
 	AuthToken     string `json:"token"`
 	AuthTokenFile string `json:"tokenfile"`
-	Repository    string `json:"repository"`
+
+	// Requests are spread across all tokens when several are configured.
+	AuthTokens     []string `json:"tokens"`
+	AuthTokenFiles []string `json:"tokenfiles"`
+
+	App           *GitHubAppConfig     `json:"app,omitempty"`
+	Endpoint      string               `json:"endpoint"`
+	CABundle      string               `json:"ca_bundle"`
+	Proxy         string               `json:"proxy"`
+	UserAgent     string               `json:"user_agent"`
+	Repository    string               `json:"repository"`
+	Repositories  []RepositoryConfig   `json:"repositories"`
+	Organizations []OrganizationConfig `json:"organizations"`
+
+	// RateLimitMaxWait is how long a collection may wait for the rate limit
+	// to be replenished before giving up with partial results.
+	RateLimitMaxWait string `json:"rate_limit_max_wait"`
+
+	// Workers bounds the number of pages fetched concurrently, and failed
+	// requests are attempted up to Retries more times (3 when unset) with an
+	// exponential backoff starting at RetryBackoff.
+	Workers      int    `json:"workers"`
+	Retries      *int   `json:"retries"`
+	RetryBackoff string `json:"retry_backoff"`
+}
+
+// CollectorConfig enables or disables a metrics collector, and holds its
+// collector specific options.
+type CollectorConfig struct {
+	Enabled *bool           `json:"enabled"`
+	Options json.RawMessage `json:"options"`
 }
 
 type InfluxConfig struct {
@@ -18,16 +79,165 @@ type InfluxConfig struct {
 	Database string `json:"database"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// Version selects the write API: the legacy 0.8 series API by default,
+	// or the line protocol API of InfluxDB 1.x ("1") or 2.x ("2").
+	Version string `json:"version"`
+	Org     string `json:"org"`
+	Bucket  string `json:"bucket"`
+	Token   string `json:"token"`
+
+	// Points of several batches are written together, up to BatchSize
+	// points or after FlushInterval.
+	BatchSize     int    `json:"batch_size"`
+	FlushInterval string `json:"flush_interval"`
+}
+
+type GraphiteConfig struct {
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	Prefix   string `json:"prefix"`
+}
+
+type StatsDConfig struct {
+	Address string `json:"address"`
+	Prefix  string `json:"prefix"`
+}
+
+type OTLPConfig struct {
+	Endpoint           string            `json:"endpoint"`
+	Encoding           string            `json:"encoding"`
+	Headers            map[string]string `json:"headers"`
+	ResourceAttributes map[string]string `json:"resource_attributes"`
+}
+
+type SQLConfig struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+}
+
+type PrometheusConfig struct {
+	Listen string `json:"listen"`
+}
+
+// EventsConfig sets where events are consumed from and how they are attached
+// to repositories. The Source is the queue to consume ("nsq", "kafka" or
+// "amqp"), which defaults to the only one configured. Events about a
+// repository which isn't tracked go to the DefaultRepository if set, and are
+// otherwise dropped or counted depending on Untracked ("drop" or "count").
+type EventsConfig struct {
+	Source            string `json:"source"`
+	DefaultRepository string `json:"default_repository"`
+	Untracked         string `json:"untracked"`
+}
+
+// SpoolConfig sets the limits of the spool of batches which failed to be
+// sent to an output.
+type SpoolConfig struct {
+	Path         string `json:"path"`
+	MaxSize      int64  `json:"max_size"`
+	MaxAge       string `json:"max_age"`
+	RetryBackoff string `json:"retry_backoff"`
+	MaxBackoff   string `json:"max_backoff"`
+	MaxAttempts  int    `json:"max_attempts"`
+}
+
+// OutputConfig is an entry of the `outputs` list. The configuration blocks
+// of the entry take precedence over the global ones for that output.
+type OutputConfig struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	InfluxDBConfig   *InfluxConfig     `json:"influxdb,omitempty"`
+	PrometheusConfig *PrometheusConfig `json:"prometheus,omitempty"`
+	GraphiteConfig   *GraphiteConfig   `json:"graphite,omitempty"`
+	StatsDConfig     *StatsDConfig     `json:"statsd,omitempty"`
+	OTLPConfig       *OTLPConfig       `json:"otlp,omitempty"`
+	SQLConfig        *SQLConfig        `json:"sql,omitempty"`
+	SpoolConfig      *SpoolConfig      `json:"spool,omitempty"`
 }
 
 type Config struct {
-	Output          string `json:"output"`
-	StoreEndpoint   string `json:"store"`
-	UpdateFrequency string `json:"update_frequency"`
+	Output          string         `json:"output"`
+	Outputs         []OutputConfig `json:"outputs"`
+	StoreEndpoint   string         `json:"store"`
+	UpdateFrequency string         `json:"update_frequency"`
+
+	GitHubConfig     GitHubConfig               `json:"github"`
+	Collectors       map[string]CollectorConfig `json:"collectors"`
+	InfluxDBConfig   InfluxConfig               `json:"influxdb"`
+	PrometheusConfig PrometheusConfig           `json:"prometheus"`
+	GraphiteConfig   GraphiteConfig             `json:"graphite"`
+	StatsDConfig     StatsDConfig               `json:"statsd"`
+	OTLPConfig       OTLPConfig                 `json:"otlp"`
+	SQLConfig        SQLConfig                  `json:"sql"`
+	SpoolConfig      *SpoolConfig               `json:"spool,omitempty"`
+	CacheConfig      *cache.Config              `json:"cache,omitempty"`
+	EventsConfig     EventsConfig               `json:"events"`
+	NSQConfig        *nsq.Config                `json:"nsq,omitempty"`
+	KafkaConfig      *kafka.Config              `json:"kafka,omitempty"`
+	AMQPConfig       *amqp.Config               `json:"amqp,omitempty"`
+	WebhookConfig    *webhook.Config            `json:"webhook,omitempty"`
+}
 
-	GitHubConfig   GitHubConfig `json:"github"`
-	InfluxDBConfig InfluxConfig `json:"influxdb"`
-	NSQConfig      *nsq.Config  `json:"nsq,omitempty"`
+// ForOutput returns the configuration of an entry of the `outputs` list.
+// Only the entry's own spool block applies to it.
+func (c *Config) ForOutput(o OutputConfig) *Config {
+	result := *c
+	result.Output = o.Type
+	result.SpoolConfig = o.SpoolConfig
+	if o.InfluxDBConfig != nil {
+		result.InfluxDBConfig = *o.InfluxDBConfig
+	}
+	if o.PrometheusConfig != nil {
+		result.PrometheusConfig = *o.PrometheusConfig
+	}
+	if o.GraphiteConfig != nil {
+		result.GraphiteConfig = *o.GraphiteConfig
+	}
+	if o.StatsDConfig != nil {
+		result.StatsDConfig = *o.StatsDConfig
+	}
+	if o.OTLPConfig != nil {
+		result.OTLPConfig = *o.OTLPConfig
+	}
+	if o.SQLConfig != nil {
+		result.SQLConfig = *o.SQLConfig
+	}
+	return &result
+}
+
+// TrackedRepositories returns the list of repositories to collect, merging
+// the legacy single `repository` setting with the `repositories` list. Any
+// repository without its own update frequency inherits the global one.
+func (c *Config) TrackedRepositories() []RepositoryConfig {
+	var repos []RepositoryConfig
+	if c.GitHubConfig.Repository != "" {
+		repos = append(repos, RepositoryConfig{Name: c.GitHubConfig.Repository})
+	}
+	repos = append(repos, c.GitHubConfig.Repositories...)
+
+	for i := range repos {
+		if repos[i].UpdateFrequency == "" {
+			repos[i].UpdateFrequency = c.UpdateFrequency
+		}
+	}
+	return repos
+}
+
+// TrackedOrganizations returns the organizations to discover repositories
+// from, with default refresh and update frequencies filled in.
+func (c *Config) TrackedOrganizations() []OrganizationConfig {
+	orgs := append([]OrganizationConfig(nil), c.GitHubConfig.Organizations...)
+	for i := range orgs {
+		if orgs[i].UpdateFrequency == "" {
+			orgs[i].UpdateFrequency = c.UpdateFrequency
+		}
+		if orgs[i].RefreshFrequency == "" {
+			orgs[i].RefreshFrequency = DefaultRefreshFrequency
+		}
+	}
+	return orgs
 }
 
 func Load(filename string) (*Config, error) {
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestTrackedRepositories(t *testing.T) {
+	c := &Config{
+		UpdateFrequency: "30m",
+		GitHubConfig: GitHubConfig{
+			Repository: "docker/docker",
+			Repositories: []RepositoryConfig{
+				{Name: "docker/swarm", UpdateFrequency: "1h"},
+				{Name: "docker/compose"},
+			},
+		},
+	}
+
+	expected := []RepositoryConfig{
+		{Name: "docker/docker", UpdateFrequency: "30m"},
+		{Name: "docker/swarm", UpdateFrequency: "1h"},
+		{Name: "docker/compose", UpdateFrequency: "30m"},
+	}
+	repos := c.TrackedRepositories()
+	if len(repos) != len(expected) {
+		t.Fatalf("Expected %d repositories but got %v\n", len(expected), repos)
+	}
+	for i := range expected {
+		if repos[i] != expected[i] {
+			t.Fatalf("Expected repository %v but got %v\n", expected[i], repos[i])
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package delivery
+
+import "net/http"
+
+// Handler processes the payload of a GitHub event of the given type, which
+// is empty when the event source doesn't carry it.
+type Handler interface {
+	HandleEvent(event string, payload []byte) error
+}
+
+// permanent is implemented by errors which would happen again if the event
+// was delivered again.
+type permanent interface {
+	Permanent() bool
+}
+
+// IsPermanent returns whether handling the event again would fail the same
+// way, in which case retrying it is pointless.
+func IsPermanent(err error) bool {
+	p, ok := err.(permanent)
+	return ok && p.Permanent()
+}
+
+type markedError struct {
+	error
+}
+
+func (markedError) Permanent() bool {
+	return true
+}
+
+// Permanent marks the error as one which would happen again on retry.
+func Permanent(err error) error {
+	return markedError{err}
+}
+
+// RejectedStatus returns whether an HTTP response status means the request
+// was rejected as invalid, and would be again if retried. Authentication and
+// server errors may be fixed in the meantime.
+func RejectedStatus(code int) bool {
+	switch code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
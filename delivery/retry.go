@@ -0,0 +1,60 @@
+package delivery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+)
+
+// ErrStopped is returned when the event source is stopped before an event is
+// handled or given up on.
+var ErrStopped = errors.New("event source stopped")
+
+// Retrier passes events to a Handler, retrying them on failure with an
+// exponential backoff from Delay up to MaxDelay, for at most MaxAttempts.
+type Retrier struct {
+	Handler     Handler
+	MaxAttempts int
+	Delay       time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns how long to wait after the given failed attempt, counted
+// from 1.
+func (r *Retrier) Backoff(attempt int) time.Duration {
+	delay := r.Delay << uint(attempt-1)
+	if delay > r.MaxDelay || delay <= 0 {
+		delay = r.MaxDelay
+	}
+	return delay
+}
+
+// Handle passes the event to the handler until it succeeds. It gives up
+// right away on permanent errors, or after MaxAttempts, and returns the last
+// error. ErrStopped is returned if stop is closed while waiting to retry.
+func (r *Retrier) Handle(event string, payload []byte, stop <-chan struct{}, fields map[string]interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := r.Handler.HandleEvent(event, payload)
+		if err == nil || IsPermanent(err) || attempt >= r.MaxAttempts {
+			return err
+		}
+
+		delay := r.Backoff(attempt)
+		log.Logger.WithFields(fields).Warnf("Retrying message in %v: %v", delay, err)
+		if !Sleep(delay, stop) {
+			return ErrStopped
+		}
+	}
+}
+
+// Sleep waits for the given duration, and returns false if stop is closed
+// first.
+func Sleep(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
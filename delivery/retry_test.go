@@ -0,0 +1,64 @@
+package delivery
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "bad payload" }
+func (permanentError) Permanent() bool { return true }
+
+type failingHandler struct {
+	errs     []error
+	attempts int
+}
+
+func (h *failingHandler) HandleEvent(string, []byte) error {
+	h.attempts++
+	if len(h.errs) == 0 {
+		return nil
+	}
+	err := h.errs[0]
+	h.errs = h.errs[1:]
+	return err
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	r := &Retrier{Delay: time.Second, MaxDelay: 3 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if delay := r.Backoff(attempt + 1); delay != expected {
+			t.Fatalf("Expected attempt %d to be retried in %v but got %v\n", attempt+1, expected, delay)
+		}
+	}
+}
+
+func TestRetrierHandle(t *testing.T) {
+	for _, tc := range []struct {
+		errs     []error
+		attempts int
+		failed   bool
+	}{
+		{[]error{errors.New("a")}, 2, false},
+		{[]error{permanentError{}}, 1, true},
+		{[]error{errors.New("a"), errors.New("b"), errors.New("c")}, 3, true},
+	} {
+		h := &failingHandler{errs: tc.errs}
+		r := &Retrier{Handler: h, MaxAttempts: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+		err := r.Handle("", nil, nil, nil)
+		if h.attempts != tc.attempts || (err != nil) != tc.failed {
+			t.Fatalf("Expected %d attempts (failed: %v) but got %d: %v\n", tc.attempts, tc.failed, h.attempts, err)
+		}
+	}
+}
+
+func TestRetrierStopped(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+	r := &Retrier{Handler: &failingHandler{errs: []error{errors.New("a")}}, MaxAttempts: 3, Delay: time.Hour, MaxDelay: time.Hour}
+	if err := r.Handle("", nil, stop, nil); err != ErrStopped {
+		t.Fatalf("Expected the retries to stop but got %v\n", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/github"
+	"github.com/icecrime/octostats/log"
+)
+
+// discoverer periodically lists the repositories of an organization and
+// keeps the scheduler in sync with the matching set.
+type discoverer struct {
+	config    config.OrganizationConfig
+	frequency time.Duration
+	refresh   time.Duration
+	sched     *scheduler
+	tracked   map[string]bool
+}
+
+func newDiscoverer(c config.OrganizationConfig, sched *scheduler) (*discoverer, error) {
+	frequency, err := time.ParseDuration(c.UpdateFrequency)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := time.ParseDuration(c.RefreshFrequency)
+	if err != nil {
+		return nil, err
+	}
+	return &discoverer{
+		config:    c,
+		frequency: frequency,
+		refresh:   refresh,
+		sched:     sched,
+		tracked:   make(map[string]bool),
+	}, nil
+}
+
+// run refreshes the discovered repositories until stop is closed. The first
+// discovery is left to the caller.
+func (d *discoverer) run(stop chan struct{}) {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.sync()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (d *discoverer) sync() {
+	logger := log.Logger.WithField("organization", d.config.Name)
+	logger.Debug("Discovering repositories")
+
+	names, err := github.DiscoverRepositories(ghClient, &d.config)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	current := make(map[string]bool)
+	for _, name := range names {
+		source, err := github.NewGitHubRepository(ghClient, name)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		current[scheduleKey(source.Nwo())] = true
+
+		// Only remember repositories we actually scheduled, so that we never
+		// untrack one that was explicitly configured.
+		if d.sched.Add(source, d.frequency) {
+			d.tracked[scheduleKey(source.Nwo())] = true
+		}
+	}
+
+	for key := range d.tracked {
+		if !current[key] {
+			d.sched.Remove(key)
+			delete(d.tracked, key)
+		}
+	}
+}
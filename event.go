@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/events"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+const (
+	untrackedDrop  = "drop"
+	untrackedCount = "count"
+)
+
+func NewEventHandler() *EventHandler {
+	return &EventHandler{store: store, config: globalConfig.EventsConfig}
+}
+
+// EventHandler turns GitHub events, whether they come from the queue or from
+// webhook deliveries, into metrics of the repository they are about.
+type EventHandler struct {
+	store  Store
+	config config.EventsConfig
+}
+
+// HandleEvent processes the payload of an event of the given type. Events
+// which carry no metrics are ignored.
+func (h *EventHandler) HandleEvent(event string, payload []byte) error {
+	items, err := events.Metrics(event, payload)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		log.Logger.WithField("event", event).Debug("Event ignored")
+		return nil
+	}
+
+	fullName := events.Repository(payload)
+	origin := h.route(fullName)
+	if origin == nil {
+		return h.untracked(fullName)
+	}
+
+	stats := metrics.New(origin)
+	stats.Events = true
+	stats.Add(items...)
+	return h.store.Send(stats)
+}
+
+// route returns the tracked repository an event is about, or the default
+// one. Events without a repository go to the first tracked repository when
+// there is no default.
+func (h *EventHandler) route(fullName string) repository.Repository {
+	if fullName != "" {
+		if r := sched.Lookup(nwoOf(fullName)); r != nil {
+			return r
+		}
+	}
+	if h.config.DefaultRepository != "" {
+		nwo := nwoOf(h.config.DefaultRepository)
+		if r := sched.Lookup(nwo); r != nil {
+			return r
+		}
+		return repository.Named(nwo)
+	}
+	if fullName == "" {
+		return defaultSource()
+	}
+	return nil
+}
+
+func (h *EventHandler) untracked(fullName string) error {
+	logger := log.Logger.WithField("repository", fullName)
+	if fullName == "" {
+		logger.Warn("Event ignored: no repository to attach it to")
+		return nil
+	}
+	if h.config.Untracked != untrackedCount {
+		logger.Debug("Event ignored: untracked repository")
+		return nil
+	}
+
+	stats := metrics.New(repository.Named(nwoOf(fullName)))
+	stats.Events = true
+	stats.Add(metrics.NewMetric("events.untracked", map[string]interface{}{"count": 1}))
+	return h.store.Send(stats)
+}
+
+// nwoOf converts a repository full name to the form of Repository.Nwo.
+func nwoOf(fullName string) string {
+	return strings.Replace(fullName, "/", ".", 1)
+}
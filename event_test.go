@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/github"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+type recordingStore struct {
+	sent []*metrics.Metrics
+}
+
+func (s *recordingStore) Send(m *metrics.Metrics) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func TestEventAtStartup(t *testing.T) {
+	recorder := &recordingStore{}
+	store, globalConfig, sched = recorder, &config.Config{}, newScheduler()
+	ghClient = &github.Client{}
+	tracked = []trackedRepository{{source: repository.Named("docker.docker"), frequency: time.Hour}}
+	defer func() { tracked = nil }()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	startCollection(stop)
+	defer sched.Stop()
+
+	payload := []byte(`{"action":"opened","issue":{},"repository":{"full_name":"Docker/docker"}}`)
+	if err := NewEventHandler().HandleEvent("issues", payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.sent) != 1 || recorder.sent[0].Origin.Nwo() != "docker.docker" {
+		t.Fatalf("Expected event to be routed to the tracked repository but got %v\n", recorder.sent)
+	}
+}
@@ -0,0 +1,314 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/icecrime/octostats/metrics"
+)
+
+// parser computes the metrics of a GitHub event from its payload.
+type parser func(payload []byte) ([]metrics.Metric, error)
+
+var parsers = map[string]parser{
+	"issues":                      parseIssues,
+	"pull_request":                parsePullRequest,
+	"pull_request_review":         parsePullRequestReview,
+	"pull_request_review_comment": parsePullRequestReviewComment,
+	"issue_comment":               parseIssueComment,
+	"push":                        parsePush,
+	"release":                     parseRelease,
+	"status":                      parseStatus,
+	"check_run":                   parseCheckRun,
+}
+
+// PayloadError is returned for events which payload can't be decoded, and
+// which would fail again if retried.
+type PayloadError struct {
+	Event string
+	Err   error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("bad %s event payload: %v", e.Event, e.Err)
+}
+
+// Permanent tells that the failure doesn't depend on when the event is
+// handled.
+func (e *PayloadError) Permanent() bool {
+	return true
+}
+
+// Metrics returns the metrics of an event of the given type. Events from
+// queues may come without a type, in which case it is guessed from the
+// payload. Unknown events have no metrics.
+func Metrics(event string, payload []byte) ([]metrics.Metric, error) {
+	if event == "" {
+		if !json.Valid(payload) {
+			return nil, &PayloadError{Event: "untyped", Err: fmt.Errorf("invalid JSON")}
+		}
+		event = guessType(payload)
+	}
+	parse, ok := parsers[event]
+	if !ok {
+		return nil, nil
+	}
+
+	items, err := parse(payload)
+	if err != nil {
+		return nil, &PayloadError{Event: event, Err: err}
+	}
+	return items, nil
+}
+
+// Repository returns the full name (`owner/name`) of the repository an event
+// is about, or an empty string if the payload doesn't say.
+func Repository(payload []byte) string {
+	var p struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	json.Unmarshal(payload, &p)
+	return p.Repository.FullName
+}
+
+// guessType infers the type of an event from the objects its payload holds.
+func guessType(payload []byte) string {
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	has := func(keys ...string) bool {
+		for _, k := range keys {
+			if _, ok := p[k]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("pull_request", "review"):
+		return "pull_request_review"
+	case has("pull_request", "comment"):
+		return "pull_request_review_comment"
+	case has("issue", "comment"):
+		return "issue_comment"
+	case has("pull_request"):
+		return "pull_request"
+	case has("issue"):
+		return "issues"
+	case has("check_run"):
+		return "check_run"
+	case has("release"):
+		return "release"
+	case has("commits", "ref"):
+		return "push"
+	case has("sha", "state", "context"):
+		return "status"
+	}
+	return ""
+}
+
+type label struct {
+	Name string `json:"name"`
+}
+
+// labelMetric returns the metric of a label added to or removed from an issue
+// or pull request.
+func labelMetric(action, kind string, l *label) []metrics.Metric {
+	change := map[string]string{"labeled": "added", "unlabeled": "removed"}[action]
+	if change == "" || l == nil {
+		return nil
+	}
+	return []metrics.Metric{metrics.NewMetric("labels.events."+change, map[string]interface{}{
+		"count": 1,
+		"name":  l.Name,
+		"kind":  kind,
+	})}
+}
+
+func parseIssues(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Action string `json:"action"`
+		Label  *label `json:"label"`
+		Issue  struct {
+			CreatedAt time.Time  `json:"created_at"`
+			ClosedAt  *time.Time `json:"closed_at"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+
+	switch p.Action {
+	case "opened", "reopened":
+		return []metrics.Metric{metrics.NewMetric("issues.events."+p.Action, map[string]interface{}{"count": 1})}, nil
+	case "closed":
+		items := []metrics.Metric{metrics.NewMetric("issues.events.closed", map[string]interface{}{"count": 1})}
+		if p.Issue.ClosedAt != nil {
+			hours := int(p.Issue.ClosedAt.Sub(p.Issue.CreatedAt).Hours())
+			items = append(items, metrics.NewMetric("issues.close_delay", map[string]interface{}{metrics.ValueField: hours}))
+		}
+		return items, nil
+	}
+	return labelMetric(p.Action, "issue", p.Label), nil
+}
+
+func parsePullRequest(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Action      string `json:"action"`
+		Label       *label `json:"label"`
+		PullRequest struct {
+			CreatedAt time.Time  `json:"created_at"`
+			ClosedAt  *time.Time `json:"closed_at"`
+			Merged    bool       `json:"merged"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+
+	switch p.Action {
+	case "opened", "reopened":
+		return []metrics.Metric{metrics.NewMetric("pull_requests.events."+p.Action, map[string]interface{}{"count": 1})}, nil
+	case "closed":
+		items := []metrics.Metric{metrics.NewMetric("pull_requests.events.closed", map[string]interface{}{
+			"count":  1,
+			"merged": p.PullRequest.Merged,
+		})}
+		if p.PullRequest.ClosedAt != nil {
+			mergeString := map[bool]string{true: "merged", false: "not_merged"}
+			metricsPath := fmt.Sprintf("pull_requests.close_delay.%s", mergeString[p.PullRequest.Merged])
+			hours := int(p.PullRequest.ClosedAt.Sub(p.PullRequest.CreatedAt).Hours())
+			items = append(items, metrics.NewMetric(metricsPath, map[string]interface{}{metrics.ValueField: hours}))
+		}
+		return items, nil
+	}
+	return labelMetric(p.Action, "pull_request", p.Label), nil
+}
+
+func parsePullRequestReview(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Action string `json:"action"`
+		Review struct {
+			State string `json:"state"`
+		} `json:"review"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Action != "submitted" {
+		return nil, err
+	}
+	return []metrics.Metric{metrics.NewMetric("pull_requests.reviews", map[string]interface{}{
+		"count": 1,
+		"state": p.Review.State,
+	})}, nil
+}
+
+func parsePullRequestReviewComment(payload []byte) ([]metrics.Metric, error) {
+	return parseCreatedComment(payload, "pull_requests.review_comments")
+}
+
+func parseIssueComment(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Issue struct {
+			PullRequest *json.RawMessage `json:"pull_request"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+
+	// Comments on the conversation of a pull request are issue comments.
+	if p.Issue.PullRequest != nil {
+		return parseCreatedComment(payload, "pull_requests.comments")
+	}
+	return parseCreatedComment(payload, "issues.comments")
+}
+
+func parseCreatedComment(payload []byte, path string) ([]metrics.Metric, error) {
+	var p struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Action != "created" {
+		return nil, err
+	}
+	return []metrics.Metric{metrics.NewMetric(path, map[string]interface{}{"count": 1})}, nil
+}
+
+func parsePush(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Ref     string            `json:"ref"`
+		Commits []json.RawMessage `json:"commits"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return []metrics.Metric{
+		metrics.NewMetric("pushes", map[string]interface{}{"count": 1, "ref": p.Ref}),
+		metrics.NewMetric("pushes.commits", map[string]interface{}{"count": len(p.Commits), "ref": p.Ref}),
+	}, nil
+}
+
+func parseRelease(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Action  string `json:"action"`
+		Release struct {
+			TagName    string `json:"tag_name"`
+			Prerelease bool   `json:"prerelease"`
+		} `json:"release"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Action != "published" {
+		return nil, err
+	}
+	return []metrics.Metric{metrics.NewMetric("releases.published", map[string]interface{}{
+		"count":      1,
+		"tag":        p.Release.TagName,
+		"prerelease": p.Release.Prerelease,
+	})}, nil
+}
+
+func parseStatus(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		State   string `json:"state"`
+		Context string `json:"context"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return []metrics.Metric{metrics.NewMetric("ci.statuses", map[string]interface{}{
+		"count":   1,
+		"state":   p.State,
+		"context": p.Context,
+	})}, nil
+}
+
+func parseCheckRun(payload []byte) ([]metrics.Metric, error) {
+	var p struct {
+		Action   string `json:"action"`
+		CheckRun struct {
+			Name        string     `json:"name"`
+			Conclusion  string     `json:"conclusion"`
+			StartedAt   time.Time  `json:"started_at"`
+			CompletedAt *time.Time `json:"completed_at"`
+		} `json:"check_run"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Action != "completed" {
+		return nil, err
+	}
+
+	items := []metrics.Metric{metrics.NewMetric("ci.check_runs", map[string]interface{}{
+		"count": 1,
+		"state": p.CheckRun.Conclusion,
+		"check": p.CheckRun.Name,
+	})}
+	if p.CheckRun.CompletedAt != nil {
+		seconds := int(p.CheckRun.CompletedAt.Sub(p.CheckRun.StartedAt).Seconds())
+		items = append(items, metrics.NewMetric("ci.check_runs.duration_seconds", map[string]interface{}{
+			metrics.ValueField: seconds,
+			"check":            p.CheckRun.Name,
+		}))
+	}
+	return items, nil
+}
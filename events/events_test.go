@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		event   string
+		payload string
+		paths   []string
+	}{
+		{"issues", `{"action":"opened","issue":{}}`, []string{"issues.events.opened"}},
+		{"issues", `{"action":"closed","issue":{"created_at":"2011-04-22T13:33:48Z","closed_at":"2011-04-23T13:33:48Z"}}`, []string{"issues.events.closed", "issues.close_delay"}},
+		{"issues", `{"action":"labeled","label":{"name":"bug"},"issue":{}}`, []string{"labels.events.added"}},
+		{"issues", `{"action":"edited","issue":{}}`, nil},
+		{"pull_request", `{"action":"closed","pull_request":{"created_at":"2011-04-22T13:33:48Z","closed_at":"2011-04-23T13:33:48Z","merged":true}}`, []string{"pull_requests.events.closed", "pull_requests.close_delay.merged"}},
+		{"pull_request_review", `{"action":"submitted","review":{"state":"approved"},"pull_request":{}}`, []string{"pull_requests.reviews"}},
+		{"issue_comment", `{"action":"created","issue":{"pull_request":{}},"comment":{}}`, []string{"pull_requests.comments"}},
+		{"push", `{"ref":"refs/heads/master","commits":[{},{}]}`, []string{"pushes", "pushes.commits"}},
+		{"check_run", `{"action":"completed","check_run":{"name":"test","conclusion":"success"}}`, []string{"ci.check_runs"}},
+		{"watch", `{"action":"started"}`, nil},
+
+		// Events from queues have no type.
+		{"", `{"action":"submitted","review":{"state":"approved"},"pull_request":{}}`, []string{"pull_requests.reviews"}},
+		{"", `{"sha":"abc","state":"failure","context":"ci"}`, []string{"ci.statuses"}},
+	} {
+		items, err := Metrics(tc.event, []byte(tc.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != len(tc.paths) {
+			t.Fatalf("Expected %d metrics for %s event %s but got %d\n", len(tc.paths), tc.event, tc.payload, len(items))
+		}
+		for i, item := range items {
+			if item.Path != tc.paths[i] {
+				t.Fatalf("Expected metric %s for %s event but got %s\n", tc.paths[i], tc.event, item.Path)
+			}
+		}
+	}
+}
+
+func TestMetricsBadPayload(t *testing.T) {
+	if _, err := Metrics("issues", []byte(`{"action":`)); err == nil {
+		t.Fatal("Expected an error for a truncated payload")
+	}
+}
+
+func TestRepository(t *testing.T) {
+	if name := Repository([]byte(`{"action":"opened","repository":{"full_name":"docker/docker"}}`)); name != "docker/docker" {
+		t.Fatalf("Expected docker/docker but got %q\n", name)
+	}
+	if name := Repository([]byte(`{"action":"opened"}`)); name != "" {
+		t.Fatalf("Expected no repository but got %q\n", name)
+	}
+}
+
+func TestMetricsUndecodable(t *testing.T) {
+	_, err := Metrics("", []byte(`not json`))
+	if e, ok := err.(*PayloadError); !ok || !e.Permanent() {
+		t.Fatalf("Expected a permanent payload error but got %v\n", err)
+	}
+}
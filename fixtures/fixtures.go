@@ -33,10 +33,26 @@ func Setup() {
 	)
 }
 
+// ServerURL returns the base URL of the test server.
+func ServerURL() string {
+	return server.URL
+}
+
 func TearDown() {
 	server.Close()
 }
 
+// HandleFunc registers a custom handler on the test server, for tests which
+// need more control over the responses than SetupMux provides.
+func HandleFunc(pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, handler)
+}
+
+// LoadFixture returns the content of the given fixture file.
+func LoadFixture(f string) string {
+	return loadFixture(f)
+}
+
 func SetupMux(t *testing.T, resourceType string) {
 	rPath := fmt.Sprintf("/repos/docker/docker/%s", resourceType)
 
@@ -0,0 +1,177 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/log"
+)
+
+const (
+	// appTokenLifetime is the validity of the JWTs we mint, GitHub accepts
+	// up to 10 minutes.
+	appTokenLifetime = 9 * time.Minute
+
+	// installationTokenMargin is how long before expiry an installation
+	// token gets refreshed.
+	installationTokenMargin = 5 * time.Minute
+)
+
+// AppAuth authenticates as a GitHub App installation. It implements the
+// octokit.AuthMethod interface, minting a new installation token whenever the
+// current one is about to expire.
+type AppAuth struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+	endpoint       string
+	httpClient     *http.Client
+
+	token   string
+	expires time.Time
+	m       sync.Mutex
+}
+
+func newAppAuth(c *config.GitHubAppConfig, endpoint string, httpClient *http.Client) (*AppAuth, error) {
+	content, err := ioutil.ReadFile(c.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppAuth{
+		appID:          c.AppID,
+		installationID: c.InstallationID,
+		key:            key,
+		endpoint:       endpoint,
+		httpClient:     httpClient,
+	}, nil
+}
+
+func parsePrivateKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key file")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return rsaKey, nil
+	}
+	return nil, fmt.Errorf("private key is not an RSA key")
+}
+
+// String returns the authorization header value for the current installation
+// token, refreshing it first if necessary.
+func (a *AppAuth) String() string {
+	token, err := a.Token()
+	if err != nil {
+		log.Logger.Errorf("Failed to refresh GitHub App installation token: %v", err)
+	}
+	return fmt.Sprintf("token %s", token)
+}
+
+// Token returns a valid installation token. Should the refresh fail, the
+// previous token is returned along with the error.
+func (a *AppAuth) Token() (string, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.token != "" && time.Now().Add(installationTokenMargin).Before(a.expires) {
+		return a.token, nil
+	}
+
+	token, expires, err := a.createInstallationToken()
+	if err != nil {
+		return a.token, err
+	}
+
+	log.Logger.WithField("expires", expires).Debug("Refreshed GitHub App installation token")
+	a.token, a.expires = token, expires
+	return a.token, nil
+}
+
+// jwt mints a token authenticating as the App itself.
+func (a *AppAuth) jwt() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		// Backdated to allow for clock drift.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appTokenLifetime).Unix(),
+		"iss": a.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+func (a *AppAuth) createInstallationToken() (string, time.Time, error) {
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	u := fmt.Sprintf("%sapp/installations/%d/access_tokens", a.endpoint, a.installationID)
+	req, err := http.NewRequest("POST", u, strings.NewReader(""))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(res.Body)
+		return "", time.Time{}, fmt.Errorf("creating installation token: %s: %s", res.Status, body)
+	}
+
+	var payload struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return "", time.Time{}, err
+	}
+	return payload.Token, payload.ExpiresAt, nil
+}
@@ -0,0 +1,86 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/fixtures"
+)
+
+func TestAppAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "octostats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, "app.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	minted := 0
+	fixtures.HandleFunc("/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(parts) != 3 {
+			t.Fatalf("Malformed JWT %v\n", parts)
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first token expires right away to force a refresh.
+		expires := time.Now().Add(time.Hour)
+		if minted == 0 {
+			expires = time.Now()
+		}
+		minted++
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token-%d", "expires_at": "%s"}`, minted, expires.Format(time.RFC3339))
+	})
+
+	c := &config.GitHubConfig{
+		Endpoint: fixtures.ServerURL(),
+		App:      &config.GitHubAppConfig{AppID: 1, PrivateKeyFile: keyFile, InstallationID: 42},
+	}
+	auth, _, err := newAuthMethod(c, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if s := auth.String(); s != "token token-2" {
+			t.Fatalf("Expected refreshed installation token but got %s\n", s)
+		}
+	}
+	if minted != 2 {
+		t.Fatalf("Expected 2 installation tokens to be minted but it was %d\n", minted)
+	}
+}
@@ -0,0 +1,140 @@
+package github
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/octokit/go-octokit/octokit"
+)
+
+// UserRepositoriesListURL is a template for the public repositories of a
+// given user, which octokit doesn't provide.
+var UserRepositoriesListURL = octokit.Hyperlink("users/{user}/repos")
+
+// currentUser holds the subset of the authenticated user payload we need.
+type currentUser struct {
+	Login string `json:"login"`
+}
+
+// ownedRepository holds the subset of the repository payload we need for
+// discovery: octokit's Repository type has no `archived` field.
+type ownedRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Fork     bool   `json:"fork"`
+	Archived bool   `json:"archived"`
+}
+
+// DiscoverRepositories lists the repositories of an organization or user
+// and returns the full names of those matching the configuration filters.
+func DiscoverRepositories(client *Client, c *config.OrganizationConfig) ([]string, error) {
+	repos, err := listRepositories(client, c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, r := range repos {
+		if matchRepository(c, &r) {
+			result = append(result, r.FullName)
+		}
+	}
+	return result, nil
+}
+
+func listRepositories(client *Client, owner string) ([]ownedRepository, error) {
+	u, err := octokit.OrgRepositoriesURL.Expand(octokit.M{"org": owner})
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := listRepositoriesPages(client, u)
+	if !isNotFound(err) {
+		return repos, err
+	}
+
+	// Not an organization: fallback to listing a user's repositories.
+	if u, err = userRepositoriesURL(client, owner); err != nil {
+		return nil, err
+	}
+	return listRepositoriesPages(client, u)
+}
+
+// userRepositoriesURL returns the link to the repositories of a user. All the
+// repositories it owns are listed when authenticated as that user, but only
+// its public ones otherwise.
+func userRepositoriesURL(client *Client, owner string) (*url.URL, error) {
+	u, err := octokit.CurrentUserURL.Expand(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var user currentUser
+	if _, err := client.fetchPage(u, nil, &user); err != nil || !strings.EqualFold(user.Login, owner) {
+		return UserRepositoriesListURL.Expand(octokit.M{"user": owner})
+	}
+
+	if u, err = octokit.UserRepositoriesURL.Expand(nil); err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("affiliation", "owner")
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// listRepositoriesPages lists the repositories from the given link and the
+// following pages, through the client rate limiter and retries.
+func listRepositoriesPages(client *Client, u *url.URL) ([]ownedRepository, error) {
+	q := u.Query()
+	q.Set("per_page", "100")
+	u.RawQuery = q.Encode()
+
+	var repos []ownedRepository
+	for u != nil {
+		var page []ownedRepository
+		res, err := client.fetchPage(u, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+		u = nextPage(res)
+	}
+	return repos, nil
+}
+
+func matchRepository(c *config.OrganizationConfig, r *ownedRepository) bool {
+	if (c.SkipArchived && r.Archived) || (c.SkipForks && r.Fork) {
+		return false
+	}
+	if len(c.Include) > 0 && !matchAny(c.Include, r) {
+		return false
+	}
+	return !matchAny(c.Exclude, r)
+}
+
+// matchAny returns whether any of the glob patterns matches the repository.
+// Patterns containing a slash are matched against the full name, others
+// against the repository name alone.
+func matchAny(patterns []string, r *ownedRepository) bool {
+	for _, p := range patterns {
+		name := r.Name
+		if strings.Contains(p, "/") {
+			name = r.FullName
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	if rerr, ok := err.(*octokit.ResponseError); ok {
+		return rerr.Response != nil && rerr.Response.StatusCode == http.StatusNotFound
+	}
+	return false
+}
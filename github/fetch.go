@@ -0,0 +1,144 @@
+package github
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+	"github.com/jingweno/go-sawyer"
+	"github.com/octokit/go-octokit/octokit"
+)
+
+const (
+	defaultWorkers      = 8
+	defaultRetries      = 3
+	defaultRetryBackoff = time.Second
+)
+
+var errNotModified = errors.New("not modified")
+
+// fetchPage retrieves a single page of results into output. When a cursor is
+// given, the request is made conditional on its validators and errNotModified
+// is returned if the resource didn't change. Transient failures are retried
+// with an exponential backoff.
+func (c *Client) fetchPage(u *url.URL, cursor *syncCursor, output interface{}) (*octokit.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.fetchPageOnce(u, cursor, output)
+		if err == nil || !isRetriable(err) || attempt >= c.retries {
+			return res, err
+		}
+
+		log.Logger.WithField("attempt", attempt+1).Debugf("Retrying %v in %v: %v", u, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (c *Client) fetchPageOnce(u *url.URL, cursor *syncCursor, output interface{}) (*octokit.Response, error) {
+	var sawyerResp *sawyer.Response
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.acquire(); err != nil {
+			return nil, err
+		}
+
+		req, err := c.NewRequest(u.String())
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor != nil {
+			if cursor.ETag != "" {
+				req.Header.Set("If-None-Match", cursor.ETag)
+			}
+			if cursor.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cursor.LastModified)
+			}
+		}
+
+		sawyerResp = req.Request.Get()
+		if sawyerResp.Response == nil {
+			break
+		}
+
+		// When rejected by the rate limit, try again once allowed to.
+		limited := c.limiter.update(sawyerResp.Response)
+		if !limited || attempt >= maxRateLimitRetries {
+			break
+		}
+		sawyerResp.Body.Close()
+	}
+
+	res, err := octokit.NewResponse(sawyerResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return res, errNotModified
+	}
+	return res, sawyerResp.Decode(output)
+}
+
+// isRetriable returns whether a request failure is worth retrying: network
+// errors, server errors and rate limit responses are. Other client errors and
+// undecodable responses would fail the same way again, and a RateLimitError
+// means the budget won't be replenished in time.
+func isRetriable(err error) bool {
+	switch err := err.(type) {
+	case *octokit.ResponseError:
+		if err.Response == nil {
+			return false
+		}
+		code := err.Response.StatusCode
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests ||
+			err.Type == octokit.ErrorTooManyRequests
+	case net.Error:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// collectResults runs the collector on every url using a bounded pool of
+// workers, and returns those for which it failed along with the last error.
+func (c *Client) collectResults(urls []*url.URL, collector func(*url.URL) error) ([]*url.URL, error) {
+	var (
+		wg      sync.WaitGroup
+		m       sync.Mutex
+		failed  []*url.URL
+		lastErr error
+	)
+
+	work := make(chan *url.URL)
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			for nu := range work {
+				if err := collector(nu); err != nil {
+					log.Logger.Debugf("Error fetching %v: %v", nu, err)
+
+					m.Lock()
+					failed = append(failed, nu)
+					lastErr = err
+					m.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, p := range urls {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+
+	return failed, lastErr
+}
@@ -3,21 +3,20 @@ package github
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/icecrime/octostats/cache"
 	"github.com/icecrime/octostats/config"
 	"github.com/icecrime/octostats/log"
 	"github.com/icecrime/octostats/repository"
 	"github.com/octokit/go-octokit/octokit"
 )
 
-const (
-	rateLimitRemaining = "X-RateLimit-Remaining"
-)
-
 func githubAuthToken(c *config.GitHubConfig) (string, error) {
 	if c.AuthToken != "" {
 		return c.AuthToken, nil
@@ -30,6 +29,28 @@ func githubAuthToken(c *config.GitHubConfig) (string, error) {
 	return string(fileContent), nil
 }
 
+// githubAuthTokens returns all the configured personal tokens, and fails if
+// there are none.
+func githubAuthTokens(c *config.GitHubConfig) ([]string, error) {
+	tokens := append([]string(nil), c.AuthTokens...)
+	for _, f := range c.AuthTokenFiles {
+		fileContent, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, strings.TrimSpace(string(fileContent)))
+	}
+
+	if c.AuthToken != "" || c.AuthTokenFile != "" || len(tokens) == 0 {
+		token, err := githubAuthToken(c)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append([]string{token}, tokens...)
+	}
+	return tokens, nil
+}
+
 func parseRepository(repo string) (string, string, error) {
 	if splitRepos := strings.Split(repo, "/"); len(splitRepos) == 2 {
 		return splitRepos[0], splitRepos[1], nil
@@ -37,32 +58,124 @@ func parseRepository(repo string) (string, string, error) {
 	return "", "", fmt.Errorf("bad repo format %s (expected username/repo)", repo)
 }
 
-func NewGitHubRepository(c *config.GitHubConfig) (repository.Repository, error) {
-	token, err := githubAuthToken(c)
+// Client is a GitHub API client meant to be shared by all tracked
+// repositories, along with the optional cache their data is persisted to and
+// the rate limit budget of its credentials.
+type Client struct {
+	*octokit.Client
+	Cache cache.Cache
+
+	// Tokens is the pool requests are spread across when several personal
+	// tokens are configured, and nil otherwise.
+	Tokens *TokenPool
+
+	limiter      *rateLimiter
+	workers      int
+	retries      int
+	retryBackoff time.Duration
+}
+
+func newClient(client *octokit.Client) *Client {
+	return &Client{
+		Client:       client,
+		limiter:      newRateLimiter(defaultRateLimitMaxWait),
+		workers:      defaultWorkers,
+		retries:      defaultRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+}
+
+// newAuthMethod returns the configured authentication: as a GitHub App
+// installation if set, or using personal access tokens otherwise. Several
+// tokens are used in turn by a pool installed as the HTTP client transport,
+// in which case no AuthMethod is returned.
+func newAuthMethod(c *config.GitHubConfig, httpClient *http.Client) (octokit.AuthMethod, *TokenPool, error) {
+	if c.App != nil {
+		auth, err := newAppAuth(c.App, apiEndpoint(c), httpClient)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Fail early on a bad App configuration.
+		if _, err := auth.Token(); err != nil {
+			return nil, nil, err
+		}
+		return auth, nil, nil
+	}
+
+	tokens, err := githubAuthTokens(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 1 {
+		return &octokit.TokenAuth{AccessToken: tokens[0]}, nil, nil
+	}
+
+	pool := newTokenPool(tokens, httpClient.Transport)
+	httpClient.Transport = pool
+	return nil, pool, nil
+}
+
+// NewClient creates a GitHub API client from the configuration.
+func NewClient(c *config.GitHubConfig) (*Client, error) {
+	httpClient, err := newHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	auth, pool, err := newAuthMethod(c, httpClient)
 	if err != nil {
 		return nil, err
 	}
-	ghClient := octokit.NewClient(&octokit.TokenAuth{AccessToken: token})
 
-	owner, name, err := parseRepository(c.Repository)
+	client := newClient(octokit.NewClientWith(apiEndpoint(c), userAgent(c), auth, httpClient))
+	client.Tokens = pool
+
+	if c.RateLimitMaxWait != "" {
+		maxWait, err := time.ParseDuration(c.RateLimitMaxWait)
+		if err != nil {
+			return nil, err
+		}
+		client.limiter = newRateLimiter(maxWait)
+	}
+	if c.RetryBackoff != "" {
+		if client.retryBackoff, err = time.ParseDuration(c.RetryBackoff); err != nil {
+			return nil, err
+		}
+	}
+	if c.Workers > 0 {
+		client.workers = c.Workers
+	}
+	if c.Retries != nil {
+		if *c.Retries < 0 {
+			return nil, fmt.Errorf("invalid number of retries %d", *c.Retries)
+		}
+		client.retries = *c.Retries
+	}
+	return client, nil
+}
+
+func NewGitHubRepository(client *Client, repo string) (repository.Repository, error) {
+	owner, name, err := parseRepository(repo)
 	if err != nil {
 		return nil, err
 	}
-	return &GitHubRepository{Owner: owner, Name: name, client: ghClient}, nil
+	return &GitHubRepository{Owner: owner, Name: name, client: client}, nil
 }
 
 func NewGitHubRepositoryWithClient(owner, name string, client *octokit.Client) *GitHubRepository {
 	return &GitHubRepository{
 		Owner:  owner,
 		Name:   name,
-		client: client,
+		client: newClient(client),
 	}
 }
 
 type GitHubRepository struct {
 	Owner  string
 	Name   string
-	client *octokit.Client
+	client *Client
+	issues issuesView
+	pulls  pullRequestsView
 }
 
 func (g *GitHubRepository) Nwo() string {
@@ -93,51 +206,136 @@ func (c *PullRequestsCollection) Add(prs ...octokit.PullRequest) {
 	c.PullRequests = append(c.PullRequests, prs...)
 }
 
+// Issues returns the issues of the repository with the given state, sorted
+// in ascending order of the given field. The local view of the repository
+// issues is brought up to date before being filtered: when that fails, the
+// last known view is returned along with a repository.PartialError.
 func (repo *GitHubRepository) Issues(state, sort string) ([]octokit.Issue, error) {
-	u, err := repo.expandURL(octokit.RepoIssuesURL, state, sort)
-	if err != nil {
-		return nil, err
+	repo.issues.m.Lock()
+	defer repo.issues.m.Unlock()
+
+	repo.loadIssues()
+	err := repo.syncIssues()
+	repo.flushIssues()
+	if err != nil && !repository.IsPartial(err) {
+		if len(repo.issues.items) == 0 {
+			return nil, err
+		}
+		log.Logger.WithField("repository", repo.Nwo()).Warnf("Using cached issues: %v", err)
+		err = &repository.PartialError{Err: err}
 	}
 
-	is := repo.client.Issues(u)
-	first, res := is.All()
-	coll := &IssuesCollection{Issues: first, m: sync.Mutex{}}
+	issues := repo.issues.filter(state, sort)
+	log.Logger.Debugf("Loaded %d %s issues", len(issues), state)
+	return issues, err
+}
+
+// PullRequests returns the pull requests of the repository with the given
+// state, sorted in ascending order of the given field. The local view of the
+// repository pull requests is brought up to date before being filtered: when
+// that fails, the last known view is returned along with a
+// repository.PartialError.
+func (repo *GitHubRepository) PullRequests(state, sort string) ([]octokit.PullRequest, error) {
+	repo.pulls.m.Lock()
+	defer repo.pulls.m.Unlock()
+
+	repo.loadPullRequests()
+	err := repo.syncPullRequests()
+	repo.flushPullRequests()
+	if err != nil && !repository.IsPartial(err) {
+		if len(repo.pulls.items) == 0 {
+			return nil, err
+		}
+		log.Logger.WithField("repository", repo.Nwo()).Warnf("Using cached pull requests: %v", err)
+		err = &repository.PartialError{Err: err}
+	}
 
-	if !res.HasError() && res.LastPage != nil {
-		lastPage := res.LastPage
-		u, _ := lastPage.Expand(nil)
-		total, _ := strconv.Atoi(u.Query().Get("page"))
+	pullRequests := repo.pulls.filter(state, sort)
+	log.Logger.Debugf("Loaded %d %s pull requests", len(pullRequests), state)
+	return pullRequests, err
+}
 
-		if getRateLimitRemaining(res.Response) <= total {
-			return coll.Issues, res.Err
+// crawlIssues performs, or resumes, the full crawl of the repository issues.
+// Pages which couldn't be retrieved are kept in the cursor backlog for the
+// next synchronization, and the crawl only completes once it is empty.
+func (repo *GitHubRepository) crawlIssues() error {
+	v := &repo.issues
+	if len(v.cursor.Backlog) == 0 {
+		// Sorting by creation date keeps pages stable during the crawl.
+		u, err := repo.expandURL(octokit.RepoIssuesURL, map[string]string{"sort": "created"})
+		if err != nil {
+			return err
 		}
 
-		urls := parseRemainingURLs(u, total)
+		var first []octokit.Issue
+		res, err := repo.client.fetchPage(u, nil, &first)
+		if err != nil {
+			return err
+		}
+		v.merge(first)
+		v.cursor.startCrawl(res)
+	}
 
-		collectResults(urls, func(nu *url.URL) {
-			is := repo.client.Issues(nu)
-			next, res := is.All()
+	coll := &IssuesCollection{m: sync.Mutex{}}
+	failed, err := repo.client.collectResults(v.cursor.backlog(), func(nu *url.URL) error {
+		var next []octokit.Issue
+		if _, err := repo.client.fetchPage(nu, nil, &next); err != nil {
+			return err
+		}
+		coll.Add(next...)
+		return nil
+	})
+	v.merge(coll.Issues)
 
-			if res.HasError() {
-				log.Logger.Debugf("Error fetching issues with %v\n", nu)
-				return
-			}
-			coll.Add(next...)
-		})
+	return v.cursor.endCrawl(failed, err)
+}
 
+// crawlPullRequests performs, or resumes, the full crawl of the repository
+// pull requests. Pages which couldn't be retrieved are kept in the cursor
+// backlog for the next synchronization, and the crawl only completes once it
+// is empty.
+func (repo *GitHubRepository) crawlPullRequests() error {
+	v := &repo.pulls
+	if len(v.cursor.Backlog) == 0 {
+		// Sorting by creation date keeps pages stable during the crawl.
+		u, err := repo.expandURL(octokit.PullRequestsURL, map[string]string{"sort": "created"})
+		if err != nil {
+			return err
+		}
+
+		var first []octokit.PullRequest
+		res, err := repo.client.fetchPage(u, nil, &first)
+		if err != nil {
+			return err
+		}
+		v.merge(first)
+		v.cursor.startCrawl(res)
 	}
 
-	log.Logger.Debugf("Loaded %d %s issues", len(coll.Issues), state)
-	return coll.Issues, res.Err
+	coll := &PullRequestsCollection{m: sync.Mutex{}}
+	failed, err := repo.client.collectResults(v.cursor.backlog(), func(nu *url.URL) error {
+		var next []octokit.PullRequest
+		if _, err := repo.client.fetchPage(nu, nil, &next); err != nil {
+			return err
+		}
+		coll.Add(next...)
+		return nil
+	})
+	v.merge(coll.PullRequests)
+
+	return v.cursor.endCrawl(failed, err)
 }
 
-func (repo *GitHubRepository) expandURL(link octokit.Hyperlink, state, sort string) (*url.URL, error) {
+func (repo *GitHubRepository) expandURL(link octokit.Hyperlink, params map[string]string) (*url.URL, error) {
 	queryParams := map[string]string{
-		"sort":      sort,
+		"sort":      "updated",
 		"direction": "asc",
-		"state":     state,
+		"state":     "all",
 		"per_page":  "100",
 	}
+	for k, v := range params {
+		queryParams[k] = v
+	}
 
 	u, err := link.Expand(octokit.M{"owner": repo.Owner, "repo": repo.Name})
 	if err != nil {
@@ -153,44 +351,6 @@ func (repo *GitHubRepository) expandURL(link octokit.Hyperlink, state, sort stri
 	return u, nil
 }
 
-func (repo *GitHubRepository) PullRequests(state, sort string) ([]octokit.PullRequest, error) {
-	u, err := repo.expandURL(octokit.PullRequestsURL, state, sort)
-	if err != nil {
-		return nil, err
-	}
-
-	is := repo.client.PullRequests(u)
-	first, res := is.All()
-	coll := &PullRequestsCollection{PullRequests: first, m: sync.Mutex{}}
-
-	if !res.HasError() && res.LastPage != nil {
-		lastPage := res.LastPage
-		u, _ := lastPage.Expand(nil)
-		total, _ := strconv.Atoi(u.Query().Get("page"))
-
-		if getRateLimitRemaining(res.Response) <= total {
-			return coll.PullRequests, res.Err
-		}
-
-		urls := parseRemainingURLs(u, total)
-
-		collectResults(urls, func(nu *url.URL) {
-			is := repo.client.PullRequests(nu)
-			next, res := is.All()
-
-			if res.HasError() {
-				log.Logger.Debugf("Error fetching pull requests with %v\n", nu)
-				return
-			}
-			coll.Add(next...)
-		})
-
-	}
-
-	log.Logger.Debugf("Loaded %d %s pull requests", len(coll.PullRequests), state)
-	return coll.PullRequests, res.Err
-}
-
 func parseRemainingURLs(origin *url.URL, total int) []*url.URL {
 	urls := make([]*url.URL, total-1)
 
@@ -207,23 +367,33 @@ func parseRemainingURLs(origin *url.URL, total int) []*url.URL {
 	return urls
 }
 
-func collectResults(urls []*url.URL, collector func(*url.URL)) {
-	var wg sync.WaitGroup
-	for _, p := range urls {
-		wg.Add(1)
+// pageCount returns the link to the last page of a paginated response along
+// with the total number of pages.
+func pageCount(res *octokit.Response) (*url.URL, int) {
+	last, ok := res.MediaHeader.Relations["last"]
+	if !ok {
+		return nil, 1
+	}
 
-		go func(nu *url.URL) {
-			defer wg.Done()
-			collector(nu)
-		}(p)
+	u, err := url.Parse(string(last))
+	if err != nil {
+		return nil, 1
 	}
-	wg.Wait()
+	total, _ := strconv.Atoi(u.Query().Get("page"))
+	return u, total
 }
 
-func getRateLimitRemaining(res *octokit.Response) int {
-	rate, err := strconv.Atoi(res.Header.Get(rateLimitRemaining))
+// nextPage returns the link to the next page of a paginated response, or nil
+// if this was the last one.
+func nextPage(res *octokit.Response) *url.URL {
+	next, ok := res.MediaHeader.Relations["next"]
+	if !ok {
+		return nil
+	}
+
+	u, err := url.Parse(string(next))
 	if err != nil {
-		rate = 60
+		return nil
 	}
-	return rate
+	return u
 }
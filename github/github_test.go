@@ -1,9 +1,22 @@
 package github
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/icecrime/octostats/cache"
+	"github.com/icecrime/octostats/config"
 	"github.com/icecrime/octostats/fixtures"
+	"github.com/icecrime/octostats/repository"
+	"github.com/octokit/go-octokit/octokit"
 )
 
 func TestAllPullRequests(t *testing.T) {
@@ -37,3 +50,303 @@ func TestAllIssues(t *testing.T) {
 		t.Fatalf("Expected 4 issues but it was %d\n", len(issues))
 	}
 }
+
+func TestMatchRepository(t *testing.T) {
+	c := &config.OrganizationConfig{
+		Include:      []string{"docker*", "moby/libnetwork"},
+		Exclude:      []string{"*-archive"},
+		SkipArchived: true,
+		SkipForks:    true,
+	}
+
+	tests := []struct {
+		repo     ownedRepository
+		expected bool
+	}{
+		{ownedRepository{Name: "docker", FullName: "moby/docker"}, true},
+		{ownedRepository{Name: "libnetwork", FullName: "moby/libnetwork"}, true},
+		{ownedRepository{Name: "swarm", FullName: "moby/swarm"}, false},
+		{ownedRepository{Name: "docker-archive", FullName: "moby/docker-archive"}, false},
+		{ownedRepository{Name: "docker-py", FullName: "moby/docker-py", Fork: true}, false},
+		{ownedRepository{Name: "docker-ce", FullName: "moby/docker-ce", Archived: true}, false},
+	}
+
+	for _, test := range tests {
+		if actual := matchRepository(c, &test.repo); actual != test.expected {
+			t.Fatalf("Expected match of %s to be %v\n", test.repo.FullName, test.expected)
+		}
+	}
+}
+
+func TestDiscoverOwnRepositories(t *testing.T) {
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	failures := 1
+	fixtures.HandleFunc("/orgs/icecrime/repos", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	fixtures.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"login": "IceCrime"}`)
+	})
+	fixtures.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if affiliation := r.URL.Query().Get("affiliation"); affiliation != "owner" {
+			t.Fatalf("Expected owned repositories to be listed but affiliation was %q\n", affiliation)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"name": "octostats", "full_name": "icecrime/octostats"}, {"name": "secret", "full_name": "icecrime/secret"}]`)
+	})
+
+	client := newClient(fixtures.Client)
+	client.retryBackoff = time.Millisecond
+
+	repos, err := DiscoverRepositories(client, &config.OrganizationConfig{Name: "icecrime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[1] != "icecrime/secret" {
+		t.Fatalf("Expected private repositories to be discovered but got %v\n", repos)
+	}
+}
+
+func TestIncrementalIssues(t *testing.T) {
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	notModified := 0
+	fixtures.HandleFunc("/repos/docker/docker/issues", func(w http.ResponseWriter, r *http.Request) {
+		if since := r.URL.Query().Get("since"); since != "" {
+			if since != "2011-04-22T14:00:00Z" {
+				t.Fatalf("Unexpected since parameter %s\n", since)
+			}
+			if r.Header.Get("If-None-Match") == `"etag"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Date", "Fri, 22 Apr 2011 14:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		fmt.Fprint(w, fixtures.LoadFixture("issues/page1.json"))
+	})
+
+	r := NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	for i := 0; i < 3; i++ {
+		issues, err := r.Issues("open", "updated")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(issues) != 1 {
+			t.Fatalf("Expected 1 issue but it was %d\n", len(issues))
+		}
+	}
+
+	if notModified != 1 {
+		t.Fatalf("Expected 1 conditional request to hit but it was %d\n", notModified)
+	}
+}
+
+func TestCachedIssues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octostats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := cache.NewFile(path.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	fixtures.Setup()
+	fixtures.SetupMux(t, "issues")
+
+	r := NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	r.client.Cache = c
+	if _, err := r.Issues("open", "updated"); err != nil {
+		t.Fatal(err)
+	}
+
+	// With GitHub unavailable, a new repository object is served from cache.
+	fixtures.TearDown()
+
+	r = NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	r.client.Cache = c
+	r.client.retries = 0
+	issues, err := r.Issues("open", "updated")
+	if !repository.IsPartial(err) {
+		t.Fatalf("Expected partial results but got %v\n", err)
+	}
+
+	if len(issues) != 4 {
+		t.Fatalf("Expected 4 cached issues but it was %d\n", len(issues))
+	}
+}
+
+func TestCachedCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octostats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := cache.NewFile(path.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	fixtures.HandleFunc("/repos/docker/docker/issues", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Date", "Fri, 22 Apr 2011 14:00:00 GMT")
+		if r.URL.Query().Get("since") != "" {
+			// Nothing new, but the validators changed.
+			w.Header().Set("ETag", `"updated"`)
+			fmt.Fprint(w, "[]")
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		fmt.Fprint(w, fixtures.LoadFixture("issues/page1.json"))
+	})
+
+	r := NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	r.client.Cache = c
+	for i := 0; i < 2; i++ {
+		if _, err := r.Issues("open", "updated"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r = NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	r.client.Cache = c
+	r.loadIssues()
+	if etag := r.issues.cursor.ETag; etag != `"updated"` {
+		t.Fatalf("Expected cursor to be persisted without new issues but ETag was %s\n", etag)
+	}
+}
+
+func TestRetryPages(t *testing.T) {
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	failures := map[string]int{"2": 1, "4": 10}
+	fixtures.HandleFunc("/repos/docker/docker/pulls", func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if failures[page] > 0 {
+			failures[page]--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if page == "" {
+			page = "1"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/docker/docker/pulls?page=4>; rel="last"`, r.Host))
+		fmt.Fprint(w, fixtures.LoadFixture("pulls/page"+page+".json"))
+	})
+
+	r := NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	r.client.workers = 1
+	r.client.retries = 2
+	r.client.retryBackoff = time.Millisecond
+
+	prs, err := r.PullRequests("open", "updated")
+	if !repository.IsPartial(err) {
+		t.Fatalf("Expected partial results but got %v\n", err)
+	}
+	if len(prs) != 3 {
+		t.Fatalf("Expected 3 prs but it was %d\n", len(prs))
+	}
+
+	// The missing page is fetched again on the next call.
+	failures["4"] = 0
+	if prs, err = r.PullRequests("open", "updated"); err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 4 {
+		t.Fatalf("Expected 4 prs but it was %d\n", len(prs))
+	}
+}
+
+func TestEnterpriseEndpoint(t *testing.T) {
+	fixtures.Setup()
+	defer fixtures.TearDown()
+
+	fixtures.HandleFunc("/api/v3/repos/docker/docker/issues", func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "octostats-test" {
+			t.Fatalf("Unexpected user agent %s\n", ua)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, fixtures.LoadFixture("issues/page1.json"))
+	})
+
+	client, err := NewClient(&config.GitHubConfig{
+		AuthToken: "token",
+		Endpoint:  fixtures.ServerURL() + "/api/v3",
+		UserAgent: "octostats-test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewGitHubRepository(client, "docker/docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues, err := r.Issues("open", "updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues) != 1 {
+		t.Fatalf("Expected 1 issue but it was %d\n", len(issues))
+	}
+}
+
+func TestRetriableErrors(t *testing.T) {
+	responseError := func(code int, errType octokit.ResponseErrorType) error {
+		return &octokit.ResponseError{Response: &http.Response{StatusCode: code}, Type: errType}
+	}
+
+	for _, tc := range []struct {
+		err       error
+		retriable bool
+	}{
+		{&url.Error{Op: "Get", URL: "https://api.github.com", Err: errors.New("connection reset")}, true},
+		{responseError(http.StatusBadGateway, octokit.ErrorBadGateway), true},
+		{responseError(http.StatusTooManyRequests, octokit.ErrorClientError), true},
+		{responseError(http.StatusForbidden, octokit.ErrorTooManyRequests), true},
+		{responseError(http.StatusForbidden, octokit.ErrorForbidden), false},
+		{responseError(http.StatusNotFound, octokit.ErrorNotFound), false},
+		{&json.SyntaxError{}, false},
+		{&RateLimitError{Until: time.Now().Add(time.Hour)}, false},
+		{errNotModified, false},
+	} {
+		if isRetriable(tc.err) != tc.retriable {
+			t.Fatalf("Expected retriable to be %v for %#v\n", tc.retriable, tc.err)
+		}
+	}
+}
+
+func TestDisableRetries(t *testing.T) {
+	retries := 0
+	client, err := NewClient(&config.GitHubConfig{AuthToken: "token", Retries: &retries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.retries != 0 {
+		t.Fatalf("Expected retries to be disabled but got %d\n", client.retries)
+	}
+}
@@ -0,0 +1,141 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/icecrime/octostats/log"
+	"github.com/octokit/go-octokit/octokit"
+)
+
+const (
+	issuesCacheKind       = "issues"
+	pullRequestsCacheKind = "pulls"
+)
+
+// loadIssues seeds the local view of issues from the cache on first use. The
+// caller must hold the view lock.
+func (repo *GitHubRepository) loadIssues() {
+	v := &repo.issues
+	if v.loaded || repo.client.Cache == nil {
+		return
+	}
+	v.loaded = true
+
+	raw, cursor, err := repo.client.Cache.Load(repo.Nwo(), issuesCacheKind)
+	if err != nil {
+		log.Logger.WithField("repository", repo.Nwo()).Warnf("Failed to load cached issues: %v", err)
+		return
+	}
+
+	issues := make([]octokit.Issue, 0, len(raw))
+	for _, r := range raw {
+		var i octokit.Issue
+		if err := json.Unmarshal(r, &i); err != nil {
+			log.Logger.WithField("repository", repo.Nwo()).Warnf("Invalid cached issue: %v", err)
+			continue
+		}
+		issues = append(issues, i)
+	}
+
+	v.merge(issues)
+	v.pending = make(map[int]bool)
+	if cursor != nil {
+		json.Unmarshal(cursor, &v.cursor)
+		v.flushed = cursor
+	}
+	log.Logger.WithField("repository", repo.Nwo()).Debugf("Loaded %d cached issues", len(issues))
+}
+
+// flushIssues persists the issues updated since the last flush, along with
+// the current cursor when either changed. The caller must hold the view lock.
+func (repo *GitHubRepository) flushIssues() {
+	v := &repo.issues
+	if repo.client.Cache == nil {
+		return
+	}
+	cursor, err := json.Marshal(&v.cursor)
+	if err != nil || len(v.pending) == 0 && bytes.Equal(cursor, v.flushed) {
+		return
+	}
+
+	items := make(map[int]json.RawMessage, len(v.pending))
+	for number := range v.pending {
+		if b, err := json.Marshal(v.items[number]); err == nil {
+			items[number] = b
+		}
+	}
+	if repo.storeCache(issuesCacheKind, items, cursor) {
+		v.flushed = cursor
+	}
+	v.pending = make(map[int]bool)
+}
+
+// loadPullRequests seeds the local view of pull requests from the cache on
+// first use. The caller must hold the view lock.
+func (repo *GitHubRepository) loadPullRequests() {
+	v := &repo.pulls
+	if v.loaded || repo.client.Cache == nil {
+		return
+	}
+	v.loaded = true
+
+	raw, cursor, err := repo.client.Cache.Load(repo.Nwo(), pullRequestsCacheKind)
+	if err != nil {
+		log.Logger.WithField("repository", repo.Nwo()).Warnf("Failed to load cached pull requests: %v", err)
+		return
+	}
+
+	pullRequests := make([]octokit.PullRequest, 0, len(raw))
+	for _, r := range raw {
+		var pr octokit.PullRequest
+		if err := json.Unmarshal(r, &pr); err != nil {
+			log.Logger.WithField("repository", repo.Nwo()).Warnf("Invalid cached pull request: %v", err)
+			continue
+		}
+		pullRequests = append(pullRequests, pr)
+	}
+
+	v.merge(pullRequests)
+	v.pending = make(map[int]bool)
+	if cursor != nil {
+		json.Unmarshal(cursor, &v.cursor)
+		v.flushed = cursor
+	}
+	log.Logger.WithField("repository", repo.Nwo()).Debugf("Loaded %d cached pull requests", len(pullRequests))
+}
+
+// flushPullRequests persists the pull requests updated since the last flush,
+// along with the current cursor when either changed. The caller must hold the
+// view lock.
+func (repo *GitHubRepository) flushPullRequests() {
+	v := &repo.pulls
+	if repo.client.Cache == nil {
+		return
+	}
+	cursor, err := json.Marshal(&v.cursor)
+	if err != nil || len(v.pending) == 0 && bytes.Equal(cursor, v.flushed) {
+		return
+	}
+
+	items := make(map[int]json.RawMessage, len(v.pending))
+	for number := range v.pending {
+		if b, err := json.Marshal(v.items[number]); err == nil {
+			items[number] = b
+		}
+	}
+	if repo.storeCache(pullRequestsCacheKind, items, cursor) {
+		v.flushed = cursor
+	}
+	v.pending = make(map[int]bool)
+}
+
+// storeCache writes the items and cursor to the cache, and returns whether it
+// succeeded.
+func (repo *GitHubRepository) storeCache(kind string, items map[int]json.RawMessage, cursor []byte) bool {
+	if err := repo.client.Cache.Store(repo.Nwo(), kind, items, cursor); err != nil {
+		log.Logger.WithField("repository", repo.Nwo()).Warnf("Failed to cache %s: %v", kind, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+)
+
+// TokenStats is a snapshot of the state of a token of the pool.
+type TokenStats struct {
+	Index     int
+	Remaining int
+	Reset     time.Time
+	Known     bool
+	Revoked   bool
+	Requests  int
+}
+
+type pooledToken struct {
+	token string
+	TokenStats
+}
+
+func (t *pooledToken) exhausted(now time.Time) bool {
+	return t.Known && t.Remaining <= 0 && t.Reset.After(now)
+}
+
+// TokenPool is an http.RoundTripper spreading requests across several
+// personal tokens in turn. The rate limit of each token is tracked separately:
+// a token is skipped while exhausted, and permanently once revoked (401).
+type TokenPool struct {
+	tokens    []*pooledToken
+	next      int
+	transport http.RoundTripper
+	m         sync.Mutex
+}
+
+func newTokenPool(tokens []string, transport http.RoundTripper) *TokenPool {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	p := &TokenPool{transport: transport}
+	for i, t := range tokens {
+		p.tokens = append(p.tokens, &pooledToken{token: t, TokenStats: TokenStats{Index: i}})
+	}
+	return p
+}
+
+// Stats returns the current state of all tokens of the pool.
+func (p *TokenPool) Stats() []TokenStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var stats []TokenStats
+	for _, t := range p.tokens {
+		stats = append(stats, t.TokenStats)
+	}
+	return stats
+}
+
+func (p *TokenPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*pooledToken]bool)
+	for {
+		t := p.pick(tried)
+		if t == nil {
+			return nil, fmt.Errorf("all GitHub tokens are revoked")
+		}
+		tried[t] = true
+
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		r.Header.Set("Authorization", fmt.Sprintf("token %s", t.token))
+
+		res, err := p.transport.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fail over to another token if this one can't be used, as long as
+		// the request can be replayed.
+		if !p.update(t, res) || req.Body != nil || !p.hasUntried(tried) {
+			p.rewriteRateLimit(res)
+			return res, nil
+		}
+		res.Body.Close()
+	}
+}
+
+// pick returns the next usable token which wasn't tried yet. Exhausted tokens
+// are only used when there is no other choice, picking the one to be reset
+// first, so that the response carries the rate limit to wait for.
+func (p *TokenPool) pick(tried map[*pooledToken]bool) *pooledToken {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	now := time.Now()
+	var fallback *pooledToken
+	for i := range p.tokens {
+		t := p.tokens[(p.next+i)%len(p.tokens)]
+		if t.Revoked || tried[t] {
+			continue
+		}
+		if !t.exhausted(now) {
+			p.next = (t.Index + 1) % len(p.tokens)
+			t.Requests++
+			return t
+		}
+		if fallback == nil || t.Reset.Before(fallback.Reset) {
+			fallback = t
+		}
+	}
+
+	if fallback != nil {
+		fallback.Requests++
+	}
+	return fallback
+}
+
+func (p *TokenPool) hasUntried(tried map[*pooledToken]bool) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, t := range p.tokens {
+		if !t.Revoked && !tried[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// update records the rate limit state of the token from the response, and
+// returns whether the request failed because of the token.
+func (p *TokenPool) update(t *pooledToken, res *http.Response) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		log.Logger.WithField("token", t.Index).Warn("GitHub token revoked")
+		t.Revoked = true
+		return true
+	}
+
+	remaining, errRemaining := strconv.Atoi(res.Header.Get(rateLimitRemaining))
+	reset, errReset := strconv.ParseInt(res.Header.Get(rateLimitReset), 10, 64)
+	if errRemaining == nil && errReset == nil {
+		t.Remaining, t.Reset, t.Known = remaining, time.Unix(reset, 0), true
+	}
+	return res.StatusCode == http.StatusForbidden && t.exhausted(time.Now())
+}
+
+// rewriteRateLimit replaces the rate limit headers of the response with the
+// budget of the whole pool, which is what the client rate limiter has to
+// account for: the sum of the tokens remaining requests, replenished at the
+// earliest reset time. Tokens which state is unknown count as one request.
+func (p *TokenPool) rewriteRateLimit(res *http.Response) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	now := time.Now()
+	var (
+		remaining int
+		reset     time.Time
+	)
+	for _, t := range p.tokens {
+		switch {
+		case t.Revoked:
+			continue
+		case !t.Known || !t.Reset.After(now):
+			remaining++
+		case t.Remaining > 0:
+			remaining += t.Remaining
+		}
+		if t.Known && (reset.IsZero() || t.Reset.Before(reset)) {
+			reset = t.Reset
+		}
+	}
+
+	if reset.IsZero() {
+		return
+	}
+	res.Header.Set(rateLimitRemaining, strconv.Itoa(remaining))
+	res.Header.Set(rateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+}
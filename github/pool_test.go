@@ -0,0 +1,55 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenPoolFailover(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "token revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case "token exhausted":
+			w.Header().Set(rateLimitRemaining, "0")
+			w.Header().Set(rateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set(rateLimitRemaining, "42")
+		w.Header().Set(rateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+	}))
+	defer ts.Close()
+
+	pool := newTokenPool([]string{"revoked", "exhausted", "valid"}, nil)
+	client := &http.Client{Transport: pool}
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected request %d to succeed but got status %d\n", i, res.StatusCode)
+		}
+		if remaining := res.Header.Get(rateLimitRemaining); remaining != "42" {
+			t.Fatalf("Expected pool budget of 42 but got %s\n", remaining)
+		}
+	}
+
+	stats := pool.Stats()
+	if !stats[0].Revoked {
+		t.Fatal("Expected first token to be revoked")
+	}
+	if stats[1].Requests != 1 || stats[1].Remaining != 0 {
+		t.Fatalf("Expected exhausted token to be used once but got %d requests\n", stats[1].Requests)
+	}
+	if stats[2].Requests != 3 {
+		t.Fatalf("Expected valid token to serve 3 requests but got %d\n", stats[2].Requests)
+	}
+}
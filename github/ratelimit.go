@@ -0,0 +1,120 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+)
+
+const (
+	rateLimitRemaining = "X-RateLimit-Remaining"
+	rateLimitReset     = "X-RateLimit-Reset"
+	retryAfter         = "Retry-After"
+
+	defaultRateLimitMaxWait = time.Minute
+	maxRateLimitRetries     = 3
+)
+
+// RateLimitError is returned when a request can't be made without waiting
+// longer than allowed for the rate limit to be replenished.
+type RateLimitError struct {
+	Until time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded until %v", e.Until)
+}
+
+// rateLimiter keeps track of the request budget of a GitHub client from the
+// rate limit headers of its responses. Requests are held back until the
+// budget is replenished when it runs out, or when GitHub asks us to slow down
+// through the secondary rate limit `Retry-After` header.
+type rateLimiter struct {
+	remaining int
+	reset     time.Time
+	retryAt   time.Time
+	known     bool
+	maxWait   time.Duration
+	m         sync.Mutex
+}
+
+func newRateLimiter(maxWait time.Duration) *rateLimiter {
+	return &rateLimiter{maxWait: maxWait}
+}
+
+// acquire reserves a request from the budget, waiting for it to be
+// replenished if necessary. A RateLimitError is returned if that would take
+// longer than the maximum wait.
+func (r *rateLimiter) acquire() error {
+	for {
+		wait, until := r.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		if wait > r.maxWait {
+			return &RateLimitError{Until: until}
+		}
+
+		log.Logger.WithField("until", until).Info("Rate limit exhausted: waiting")
+		time.Sleep(wait)
+	}
+}
+
+// reserve takes a request from the budget if one is available, or returns
+// how long to wait until there is.
+func (r *rateLimiter) reserve() (time.Duration, time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	now := time.Now()
+	until := r.retryAt
+	if r.known && r.remaining <= 0 && r.reset.After(until) {
+		until = r.reset
+	}
+	if until.After(now) {
+		return until.Sub(now), until
+	}
+
+	// Past the reset time, the budget is unknown until the next response.
+	if r.known && !r.reset.After(now) {
+		r.known = false
+	}
+	r.remaining--
+	return 0, until
+}
+
+// update refreshes the budget from the response headers, and returns whether
+// the request was rejected because of the rate limit.
+func (r *rateLimiter) update(res *http.Response) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	h := res.Header
+	remaining, errRemaining := strconv.Atoi(h.Get(rateLimitRemaining))
+	reset, errReset := strconv.ParseInt(h.Get(rateLimitReset), 10, 64)
+	if errRemaining == nil && errReset == nil {
+		resetTime := time.Unix(reset, 0)
+
+		// Responses to concurrent requests may arrive out of order: within a
+		// given rate limit window, the lowest remaining count is the latest.
+		if !r.known || !resetTime.Equal(r.reset) || remaining < r.remaining {
+			r.remaining = remaining
+		}
+		r.reset = resetTime
+		r.known = true
+	}
+
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+
+	if seconds, err := strconv.Atoi(h.Get(retryAfter)); err == nil {
+		r.retryAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		return true
+	}
+	return errRemaining == nil && remaining == 0
+}
@@ -0,0 +1,52 @@
+package github
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func rateLimitedResponse(status, remaining int, reset time.Time) *http.Response {
+	res := &http.Response{StatusCode: status, Header: http.Header{}}
+	res.Header.Set(rateLimitRemaining, strconv.Itoa(remaining))
+	res.Header.Set(rateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+	return res
+}
+
+func TestRateLimitExhausted(t *testing.T) {
+	r := newRateLimiter(time.Second)
+	reset := time.Now().Add(time.Hour)
+
+	if limited := r.update(rateLimitedResponse(http.StatusOK, 1, reset)); limited {
+		t.Fatal("Expected successful response not to be rate limited")
+	}
+	if err := r.acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	if limited := r.update(rateLimitedResponse(http.StatusForbidden, 0, reset)); !limited {
+		t.Fatal("Expected exhausted response to be rate limited")
+	}
+	if _, ok := r.acquire().(*RateLimitError); !ok {
+		t.Fatal("Expected acquire to fail until the reset time")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	r := newRateLimiter(5 * time.Second)
+
+	res := rateLimitedResponse(http.StatusForbidden, 100, time.Now().Add(time.Hour))
+	res.Header.Set(retryAfter, "1")
+	if limited := r.update(res); !limited {
+		t.Fatal("Expected Retry-After response to be rate limited")
+	}
+
+	start := time.Now()
+	if err := r.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("Expected acquire to honour Retry-After but it returned after %v\n", elapsed)
+	}
+}
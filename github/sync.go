@@ -0,0 +1,271 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/repository"
+	"github.com/octokit/go-octokit/octokit"
+)
+
+// syncCursor records how far a local view was synchronized with GitHub: the
+// most recent update time seen, and the validators of the last delta query
+// to make it conditional. Until the initial crawl completes, Since is zero
+// and the cursor holds the pages which remain to be fetched.
+type syncCursor struct {
+	Since        time.Time
+	ETag         string
+	LastModified string
+	CrawlStart   time.Time
+	Backlog      []string
+}
+
+func (c *syncCursor) record(res *octokit.Response) {
+	c.ETag = res.Header.Get("ETag")
+	c.LastModified = res.Header.Get("Last-Modified")
+}
+
+// startCrawl records the start of a crawl from its first page response, and
+// queues all the remaining pages in the backlog.
+func (c *syncCursor) startCrawl(res *octokit.Response) {
+	c.CrawlStart = time.Now()
+	if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+		c.CrawlStart = date
+	}
+
+	c.Backlog = nil
+	if lastPage, total := pageCount(res); total > 1 {
+		for _, u := range parseRemainingURLs(lastPage, total) {
+			c.Backlog = append(c.Backlog, u.String())
+		}
+	}
+}
+
+func (c *syncCursor) backlog() []*url.URL {
+	var urls []*url.URL
+	for _, b := range c.Backlog {
+		if u, err := url.Parse(b); err == nil {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// endCrawl keeps the failed pages in the backlog and returns an error
+// reporting the results as partial if there are any left, along with the
+// cause of the failures. Otherwise, the crawl is complete and the following
+// synchronizations will be incremental from the time the crawl started.
+func (c *syncCursor) endCrawl(failed []*url.URL, err error) error {
+	c.Backlog = nil
+	for _, u := range failed {
+		c.Backlog = append(c.Backlog, u.String())
+	}
+
+	if len(c.Backlog) > 0 {
+		return &repository.PartialError{
+			Err: fmt.Errorf("%d pages left to fetch: %v", len(c.Backlog), err),
+		}
+	}
+	c.Since = c.CrawlStart
+	return nil
+}
+
+// syncIssues brings the local view of issues up to date. Until the initial
+// crawl of all issues completes, the calls resume it; subsequent ones only
+// fetch the issues updated since the last synchronization. The caller must hold the view lock.
+func (repo *GitHubRepository) syncIssues() error {
+	v := &repo.issues
+	if v.cursor.Since.IsZero() {
+		return repo.crawlIssues()
+	}
+
+	since := v.cursor.Since
+	u, err := repo.expandURL(octokit.RepoIssuesURL, map[string]string{
+		"since": since.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	for cursor := &v.cursor; u != nil; cursor = nil {
+		var page []octokit.Issue
+		res, err := repo.client.fetchPage(u, cursor, &page)
+		if err == errNotModified {
+			log.Logger.Debugf("Issues of %s not modified since %v", repo.Nwo(), since)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if cursor != nil {
+			cursor.record(res)
+		}
+		if latest := v.merge(page); latest.After(v.cursor.Since) {
+			v.cursor.Since = latest
+		}
+		u = nextPage(res)
+	}
+
+	// The validators are only relevant to the query they were received for,
+	// which depends on the cursor position.
+	if !v.cursor.Since.Equal(since) {
+		v.cursor.ETag, v.cursor.LastModified = "", ""
+	}
+	return nil
+}
+
+// syncPullRequests brings the local view of pull requests up to date. The
+// pulls API has no `since` parameter, so after the initial crawl we walk the
+// most recently updated pull requests until reaching the last sync point.
+// The caller must hold the view lock.
+func (repo *GitHubRepository) syncPullRequests() error {
+	v := &repo.pulls
+	if v.cursor.Since.IsZero() {
+		return repo.crawlPullRequests()
+	}
+
+	since := v.cursor.Since
+	u, err := repo.expandURL(octokit.PullRequestsURL, map[string]string{
+		"direction": "desc",
+	})
+	if err != nil {
+		return err
+	}
+
+	for cursor := &v.cursor; u != nil; cursor = nil {
+		var page []octokit.PullRequest
+		res, err := repo.client.fetchPage(u, cursor, &page)
+		if err == errNotModified {
+			log.Logger.Debugf("Pull requests of %s not modified since %v", repo.Nwo(), since)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if cursor != nil {
+			cursor.record(res)
+		}
+		if latest := v.merge(page); latest.After(v.cursor.Since) {
+			v.cursor.Since = latest
+		}
+
+		if len(page) == 0 || page[len(page)-1].UpdatedAt.Before(since) {
+			break
+		}
+		u = nextPage(res)
+	}
+	return nil
+}
+
+type issuesView struct {
+	items   map[int]octokit.Issue
+	cursor  syncCursor
+	flushed []byte
+	loaded  bool
+	pending map[int]bool
+	m       sync.Mutex
+}
+
+// merge adds or updates issues in the view, and returns the most recent
+// update time among them.
+func (v *issuesView) merge(issues []octokit.Issue) time.Time {
+	if v.items == nil {
+		v.items = make(map[int]octokit.Issue)
+		v.pending = make(map[int]bool)
+	}
+
+	var latest time.Time
+	for _, i := range issues {
+		v.items[i.Number] = i
+		v.pending[i.Number] = true
+		if i.UpdatedAt.After(latest) {
+			latest = i.UpdatedAt
+		}
+	}
+	return latest
+}
+
+func (v *issuesView) filter(state, sortField string) []octokit.Issue {
+	var result []octokit.Issue
+	for _, i := range v.items {
+		if state == "all" || i.State == state {
+			result = append(result, i)
+		}
+	}
+	sort.Sort(&issuesByDate{issues: result, field: sortField})
+	return result
+}
+
+type issuesByDate struct {
+	issues []octokit.Issue
+	field  string
+}
+
+func (s *issuesByDate) Len() int      { return len(s.issues) }
+func (s *issuesByDate) Swap(i, j int) { s.issues[i], s.issues[j] = s.issues[j], s.issues[i] }
+func (s *issuesByDate) Less(i, j int) bool {
+	if s.field == "created" {
+		return s.issues[i].CreatedAt.Before(s.issues[j].CreatedAt)
+	}
+	return s.issues[i].UpdatedAt.Before(s.issues[j].UpdatedAt)
+}
+
+type pullRequestsView struct {
+	items   map[int]octokit.PullRequest
+	cursor  syncCursor
+	flushed []byte
+	loaded  bool
+	pending map[int]bool
+	m       sync.Mutex
+}
+
+// merge adds or updates pull requests in the view, and returns the most
+// recent update time among them.
+func (v *pullRequestsView) merge(pullRequests []octokit.PullRequest) time.Time {
+	if v.items == nil {
+		v.items = make(map[int]octokit.PullRequest)
+		v.pending = make(map[int]bool)
+	}
+
+	var latest time.Time
+	for _, pr := range pullRequests {
+		v.items[pr.Number] = pr
+		v.pending[pr.Number] = true
+		if pr.UpdatedAt.After(latest) {
+			latest = pr.UpdatedAt
+		}
+	}
+	return latest
+}
+
+func (v *pullRequestsView) filter(state, sortField string) []octokit.PullRequest {
+	var result []octokit.PullRequest
+	for _, pr := range v.items {
+		if state == "all" || pr.State == state {
+			result = append(result, pr)
+		}
+	}
+	sort.Sort(&pullRequestsByDate{pullRequests: result, field: sortField})
+	return result
+}
+
+type pullRequestsByDate struct {
+	pullRequests []octokit.PullRequest
+	field        string
+}
+
+func (s *pullRequestsByDate) Len() int { return len(s.pullRequests) }
+func (s *pullRequestsByDate) Swap(i, j int) {
+	s.pullRequests[i], s.pullRequests[j] = s.pullRequests[j], s.pullRequests[i]
+}
+func (s *pullRequestsByDate) Less(i, j int) bool {
+	if s.field == "created" {
+		return s.pullRequests[i].CreatedAt.Before(s.pullRequests[j].CreatedAt)
+	}
+	return s.pullRequests[i].UpdatedAt.Before(s.pullRequests[j].UpdatedAt)
+}
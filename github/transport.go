@@ -0,0 +1,71 @@
+package github
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/icecrime/octostats/config"
+)
+
+const (
+	defaultEndpoint  = "https://api.github.com/"
+	defaultUserAgent = "octostats"
+)
+
+// apiEndpoint returns the configured API base URL, such as
+// `https://github.example.com/api/v3/` for GitHub Enterprise Server. The
+// trailing slash matters as API paths are resolved relative to it.
+func apiEndpoint(c *config.GitHubConfig) string {
+	if c.Endpoint == "" {
+		return defaultEndpoint
+	}
+	if !strings.HasSuffix(c.Endpoint, "/") {
+		return c.Endpoint + "/"
+	}
+	return c.Endpoint
+}
+
+func userAgent(c *config.GitHubConfig) string {
+	if c.UserAgent == "" {
+		return defaultUserAgent
+	}
+	return c.UserAgent
+}
+
+// newHTTPClient creates the HTTP client used to reach the GitHub API, which
+// may go through a proxy and trust an additional certificate authority. It
+// keeps the timeouts and HTTP/2 support of the default transport.
+func newHTTPClient(c *config.GitHubConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy url %s: %v", c.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.CABundle != "" {
+		pem, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,182 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	defaultProtocol = "tcp"
+	defaultPrefix   = "octostats"
+	dialTimeout     = 5 * time.Second
+	writeTimeout    = 10 * time.Second
+)
+
+// New returns a store writing metrics to Carbon in the plaintext protocol.
+// The connection is established on the first Send.
+func New(c *config.GraphiteConfig) (*store, error) {
+	s := &store{
+		address:  c.Address,
+		protocol: c.Protocol,
+		prefix:   c.Prefix,
+		totals:   make(map[string]float64),
+	}
+	if s.protocol == "" {
+		s.protocol = defaultProtocol
+	}
+	if s.protocol != "tcp" && s.protocol != "udp" {
+		return nil, fmt.Errorf("unsupported Graphite protocol '%s'", s.protocol)
+	}
+	if s.prefix == "" {
+		s.prefix = defaultPrefix
+	}
+	return s, nil
+}
+
+type store struct {
+	address  string
+	protocol string
+	prefix   string
+	conn     net.Conn
+
+	// totals are the running totals of the event counts, keyed by series.
+	totals map[string]float64
+	m      sync.Mutex
+}
+
+func (s *store) Send(m *metrics.Metrics) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	lines, totals := s.format(m, m.Time)
+
+	// A connection may have been dropped by Carbon since the last write: the
+	// write is attempted a second time on a new connection.
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = s.write(lines); err == nil {
+			for name, total := range totals {
+				s.totals[name] = total
+			}
+			return nil
+		}
+		log.Logger.WithField("address", s.address).Warnf("Graphite write failed: %v", err)
+		s.close()
+	}
+	return err
+}
+
+func (s *store) write(lines [][]byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.protocol, s.address, dialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	// Each UDP datagram must hold complete lines: write them one at a time.
+	if s.protocol == "udp" {
+		for _, l := range lines {
+			if _, err := s.conn.Write(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := s.conn.Write(bytes.Join(lines, nil))
+	return err
+}
+
+func (s *store) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// format returns one `prefix.nwo.path.count value timestamp` line for each
+// metric path and set of string and boolean fields, which values are
+// appended to the path. Carbon keeps a single value per series and second:
+// per-item metrics are summed into the number of items, or the sum of their
+// `count` field. Measurements are written as `value` lines instead.
+//
+// Event counts within the same second would overwrite each other as well:
+// the running total of each series is written instead, and the new totals
+// are returned to be kept once written.
+func (s *store) format(m *metrics.Metrics, now time.Time) ([][]byte, map[string]float64) {
+	base := s.prefix + "." + sanitize(m.Origin.Nwo())
+
+	var names []string
+	values := make(map[string]float64)
+	for _, item := range m.Items {
+		name := base + "." + seriesName(item)
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		if item.IsMeasurement() {
+			values[name] = item.Value()
+		} else {
+			values[name] += item.Value()
+		}
+	}
+
+	totals := make(map[string]float64)
+	if m.Events {
+		for _, name := range names {
+			if strings.HasSuffix(name, "."+metrics.CountField) {
+				totals[name] = s.totals[name] + values[name]
+				values[name] = totals[name]
+			}
+		}
+	}
+
+	var lines [][]byte
+	for _, name := range names {
+		lines = append(lines, []byte(fmt.Sprintf("%s %v %d\n", name, values[name], now.Unix())))
+	}
+	return lines, totals
+}
+
+// seriesName returns the metric path followed by the values of its string
+// and boolean fields in the order of their names, and by `count` or `value`.
+func seriesName(item metrics.Metric) string {
+	var fields []string
+	for k, v := range item.Data {
+		switch v.(type) {
+		case string, bool:
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	parts := []string{sanitize(item.Path)}
+	for _, k := range fields {
+		parts = append(parts, sanitize(fmt.Sprint(item.Data[k])))
+	}
+	if item.IsMeasurement() {
+		return strings.Join(append(parts, metrics.ValueField), ".")
+	}
+	return strings.Join(append(parts, metrics.CountField), ".")
+}
+
+// sanitize replaces the characters which have a meaning in the plaintext
+// protocol.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,77 @@
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestSendTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 16)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	s, err := New(&config.GraphiteConfig{Address: l.Addr().String(), Prefix: "stats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"state": "closed", "merged": true, "id": 1347}))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"state": "closed", "merged": true, "id": 1348}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"stats.docker.docker.issues.open.count 4 ",
+		"stats.docker.docker.pull_requests.data.true.closed.count 2 ",
+	} {
+		if line := <-received; !strings.HasPrefix(line, expected) {
+			t.Fatalf("Expected line starting with %q but got %q\n", expected, line)
+		}
+	}
+}
+
+func TestFormatEvents(t *testing.T) {
+	s := &store{prefix: "stats", totals: map[string]float64{"stats.docker.docker.issues.events.closed.count": 2}}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Events = true
+	m.Add(metrics.NewMetric("issues.events.closed", map[string]interface{}{"count": 1}))
+	m.Add(metrics.NewMetric("issues.close_delay", map[string]interface{}{"value": 24}))
+
+	lines, totals := s.format(m, m.Time)
+	for i, expected := range []string{
+		"stats.docker.docker.issues.events.closed.count 3 ",
+		"stats.docker.docker.issues.close_delay.value 24 ",
+	} {
+		if line := string(lines[i]); !strings.HasPrefix(line, expected) {
+			t.Fatalf("Expected line starting with %q but got %q\n", expected, line)
+		}
+	}
+	if total := totals["stats.docker.docker.issues.events.closed.count"]; total != 3 {
+		t.Fatalf("Expected new total of 3 but got %v\n", total)
+	}
+}
@@ -0,0 +1,77 @@
+package influx
+
+import (
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	defaultBatchSize     = 5000
+	defaultFlushInterval = time.Second
+)
+
+// batcher merges the metrics of several Send calls into a single write,
+// which happens once the batch holds enough points or when the flush interval
+// has elapsed since its first metrics. Each Send waits for the write of its
+// batch and returns its result.
+type batcher struct {
+	write    func([]*metrics.Metrics) error
+	size     int
+	interval time.Duration
+
+	pending []*metrics.Metrics
+	points  int
+	waiters []chan error
+	timer   *time.Timer
+	m       sync.Mutex
+}
+
+func newBatcher(size int, interval time.Duration, write func([]*metrics.Metrics) error) *batcher {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &batcher{write: write, size: size, interval: interval}
+}
+
+func (b *batcher) add(m *metrics.Metrics) error {
+	done := make(chan error, 1)
+
+	b.m.Lock()
+	b.pending = append(b.pending, m)
+	b.points += len(m.Items)
+	b.waiters = append(b.waiters, done)
+	if b.points >= b.size {
+		b.m.Unlock()
+		b.flush()
+	} else {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, b.flush)
+		}
+		b.m.Unlock()
+	}
+	return <-done
+}
+
+func (b *batcher) flush() {
+	b.m.Lock()
+	pending, waiters := b.pending, b.waiters
+	b.pending, b.waiters, b.points = nil, nil, 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.m.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	err := b.write(pending)
+	for _, w := range waiters {
+		w <- err
+	}
+}
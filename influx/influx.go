@@ -2,6 +2,8 @@ package influx
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/icecrime/octostats/config"
 	"github.com/icecrime/octostats/log"
@@ -10,14 +12,38 @@ import (
 	influxClient "github.com/influxdb/influxdb/client"
 )
 
-func New(c *config.InfluxConfig) *store {
-	return &store{
-		config: c,
+const requestTimeout = 30 * time.Second
+
+// New returns a store writing metrics to the legacy 0.8 series API. An
+// unreachable server is only reported, as failed writes are spooled.
+func New(c *config.InfluxConfig) (*store, error) {
+	client, err := influxClient.NewClient(&influxClient.ClientConfig{
+		Host:       c.Endpoint,
+		Database:   c.Database,
+		Username:   c.Username,
+		Password:   c.Password,
+		HttpClient: &http.Client{Timeout: requestTimeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(); err != nil {
+		log.Logger.WithField("endpoint", c.Endpoint).Warnf("InfluxDB health check failed: %v", err)
 	}
+
+	batchSize, flushInterval, err := batchConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{config: c, client: client}
+	s.batcher = newBatcher(batchSize, flushInterval, s.write)
+	return s, nil
 }
 
 type store struct {
-	config *config.InfluxConfig
+	config  *config.InfluxConfig
+	client  *influxClient.Client
+	batcher *batcher
 }
 
 func (*store) format(metrics *metrics.Metrics) []*influxClient.Series {
@@ -45,18 +71,27 @@ func (*store) format(metrics *metrics.Metrics) []*influxClient.Series {
 }
 
 func (s *store) Send(metrics *metrics.Metrics) error {
-	client, err := influxClient.NewClient(&influxClient.ClientConfig{
-		Host:     s.config.Endpoint,
-		Database: s.config.Database,
-		Username: s.config.Username,
-		Password: s.config.Password,
-	})
-	if err != nil {
-		return err
+	return s.batcher.add(metrics)
+}
+
+func (s *store) write(batch []*metrics.Metrics) error {
+	var series []*influxClient.Series
+	for _, m := range batch {
+		series = append(series, s.format(m)...)
 	}
-	log.Logger.Debugf("Saving %d metrics for %s", len(metrics.Items), metrics.Origin.Nwo())
-	err = client.WriteSeries(s.format(metrics))
-	log.Logger.Debugf("Saving %d metrics for %s: DONE", len(metrics.Items), metrics.Origin.Nwo())
 
-	return err
+	log.Logger.Debugf("Saving %d series", len(series))
+	return s.client.WriteSeries(series)
+}
+
+// batchConfig returns the batching settings of the configuration.
+func batchConfig(c *config.InfluxConfig) (int, time.Duration, error) {
+	var flushInterval time.Duration
+	if c.FlushInterval != "" {
+		var err error
+		if flushInterval, err = time.ParseDuration(c.FlushInterval); err != nil {
+			return 0, 0, err
+		}
+	}
+	return c.BatchSize, flushInterval, nil
 }
@@ -0,0 +1,213 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	// VersionLegacy is the 0.8 series API.
+	VersionLegacy = "0.8"
+	// Version1 is the InfluxDB 1.x `/write` line protocol API.
+	Version1 = "1"
+	// Version2 is the InfluxDB 2.x `/api/v2/write` line protocol API.
+	Version2 = "2"
+)
+
+// NewLineStore returns a store writing metrics in the line protocol to the
+// API of the configured InfluxDB version. An unreachable server is only
+// reported, as failed writes are spooled.
+func NewLineStore(c *config.InfluxConfig) (*lineStore, error) {
+	endpoint := c.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.TrimSuffix(base.Path, "/")
+	ping := *base
+	ping.Path = root + "/ping"
+
+	query := url.Values{"precision": {"ns"}}
+	switch c.Version {
+	case Version1:
+		base.Path = root + "/write"
+		query.Set("db", c.Database)
+		if c.Username != "" {
+			query.Set("u", c.Username)
+			query.Set("p", c.Password)
+		}
+	case Version2:
+		base.Path = root + "/api/v2/write"
+		query.Set("org", c.Org)
+		query.Set("bucket", c.Bucket)
+	default:
+		return nil, fmt.Errorf("unsupported InfluxDB version '%s'", c.Version)
+	}
+	base.RawQuery = query.Encode()
+
+	batchSize, flushInterval, err := batchConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	s := &lineStore{
+		config: c,
+		url:    base.String(),
+		client: &http.Client{Timeout: requestTimeout},
+	}
+	s.batcher = newBatcher(batchSize, flushInterval, s.write)
+
+	if err := s.ping(ping.String()); err != nil {
+		log.Logger.WithField("endpoint", c.Endpoint).Warnf("InfluxDB health check failed: %v", err)
+	}
+	return s, nil
+}
+
+type lineStore struct {
+	config  *config.InfluxConfig
+	url     string
+	client  *http.Client
+	batcher *batcher
+}
+
+func (s *lineStore) ping(u string) error {
+	res, err := s.client.Get(u)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *lineStore) Send(m *metrics.Metrics) error {
+	return s.batcher.add(m)
+}
+
+func (s *lineStore) write(batch []*metrics.Metrics) error {
+	var body []byte
+	for _, m := range batch {
+		body = append(body, formatLines(m, m.Time)...)
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.config.Version == Version2 {
+		req.Header.Set("Authorization", "Token "+s.config.Token)
+	}
+
+	log.Logger.Debugf("Saving %d batches of metrics", len(batch))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		err := fmt.Errorf("InfluxDB write failed with status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+		if delivery.RejectedStatus(res.StatusCode) {
+			return delivery.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// formatLines formats the metrics as line protocol points at the given time,
+// one per metric. The repository and the string fields of the metric data,
+// such as the state or the label name, are written as tags.
+//
+// InfluxDB identifies points by series and timestamp: metrics of the same
+// series, such as the pull requests in a given state, are written a
+// nanosecond apart so that they don't overwrite each other.
+func formatLines(m *metrics.Metrics, now time.Time) []byte {
+	var buf bytes.Buffer
+	base := now.UnixNano()
+	seen := map[string]int64{}
+	for _, item := range m.Items {
+		tags := map[string]string{"repository": m.Origin.Nwo()}
+		fields := map[string]string{}
+		for k, v := range item.Data {
+			switch v := v.(type) {
+			case string:
+				tags[tagKey(k)] = v
+			case bool:
+				fields[k] = strconv.FormatBool(v)
+			case int:
+				fields[k] = strconv.Itoa(v) + "i"
+			case int64:
+				fields[k] = strconv.FormatInt(v, 10) + "i"
+			case float64:
+				fields[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				fields[k] = `"` + fieldEscaper.Replace(fmt.Sprint(v)) + `"`
+			}
+		}
+		if len(fields) == 0 {
+			fields["count"] = "1i"
+		}
+
+		var series bytes.Buffer
+		series.WriteString(measurementEscaper.Replace(item.Path))
+		for _, k := range sortedKeys(tags) {
+			if tags[k] != "" {
+				fmt.Fprintf(&series, ",%s=%s", tagEscaper.Replace(k), tagEscaper.Replace(tags[k]))
+			}
+		}
+		buf.Write(series.Bytes())
+		for i, k := range sortedKeys(fields) {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			fmt.Fprintf(&buf, "%s%s=%s", sep, tagEscaper.Replace(k), fields[k])
+		}
+		fmt.Fprintf(&buf, " %d\n", base+seen[series.String()])
+		seen[series.String()]++
+	}
+	return buf.Bytes()
+}
+
+// tagKey renames the label name field to a `label` tag.
+func tagKey(field string) string {
+	if field == "name" {
+		return "label"
+	}
+	return field
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	fieldEscaper       = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
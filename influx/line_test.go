@@ -0,0 +1,164 @@
+package influx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestFormatLines(t *testing.T) {
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"time": 1303479228, "state": "closed", "merged": true, "id": 1347}))
+	m.Add(metrics.NewMetric("labels.data", map[string]interface{}{"name": "kind/bug fix"}))
+
+	expected := strings.Join([]string{
+		"issues.open,repository=docker.docker count=4i 1303479300000000000",
+		"pull_requests.data,repository=docker.docker,state=closed id=1347i,merged=true,time=1303479228i 1303479300000000000",
+		`labels.data,label=kind/bug\ fix,repository=docker.docker count=1i 1303479300000000000`,
+		"",
+	}, "\n")
+	if output := string(formatLines(m, time.Unix(1303479300, 0))); output != expected {
+		t.Fatalf("Expected lines:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatLinesSameSeries(t *testing.T) {
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"state": "closed", "id": 1347}))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"state": "closed", "id": 1348}))
+	m.Add(metrics.NewMetric("labels.data", map[string]interface{}{"name": "bug"}))
+	m.Add(metrics.NewMetric("labels.data", map[string]interface{}{"name": "bug"}))
+
+	expected := strings.Join([]string{
+		"pull_requests.data,repository=docker.docker,state=closed id=1347i 1303479300000000000",
+		"pull_requests.data,repository=docker.docker,state=closed id=1348i 1303479300000000001",
+		"labels.data,label=bug,repository=docker.docker count=1i 1303479300000000000",
+		"labels.data,label=bug,repository=docker.docker count=1i 1303479300000000001",
+		"",
+	}, "\n")
+	if output := string(formatLines(m, time.Unix(1303479300, 0))); output != expected {
+		t.Fatalf("Expected lines:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestLineStoreV2(t *testing.T) {
+	var path, query, auth, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		path, query, auth, body = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	s, err := NewLineStore(&config.InfluxConfig{
+		Endpoint: ts.URL,
+		Version:  Version2,
+		Org:      "octo",
+		Bucket:   "stats",
+		Token:    "secret",
+
+		FlushInterval: "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/api/v2/write" || query != "bucket=stats&org=octo&precision=ns" {
+		t.Fatalf("Unexpected write URL %s?%s\n", path, query)
+	}
+	if auth != "Token secret" {
+		t.Fatalf("Unexpected authorization header %q\n", auth)
+	}
+	if !strings.HasPrefix(body, "issues.open,repository=docker.docker count=4i ") {
+		t.Fatalf("Unexpected body %q\n", body)
+	}
+}
+
+func TestLineStoreBatching(t *testing.T) {
+	var m sync.Mutex
+	var writes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/write" {
+			b, _ := ioutil.ReadAll(r.Body)
+			m.Lock()
+			writes = append(writes, string(b))
+			m.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	s, err := NewLineStore(&config.InfluxConfig{
+		Endpoint:      ts.URL,
+		Version:       Version1,
+		Database:      "db",
+		BatchSize:     3,
+		FlushInterval: "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three concurrent single point batches fill a write.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := metrics.New(repository.Named("docker.docker"))
+			m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+			if err := s.Send(m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(writes) != 1 || strings.Count(writes[0], "\n") != 3 {
+		t.Fatalf("Expected a single write of 3 points but got %q\n", writes)
+	}
+}
+
+func TestLineStoreHealthCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// A failed health check doesn't prevent startup, but writes still fail.
+	s, err := NewLineStore(&config.InfluxConfig{
+		Endpoint:      ts.URL,
+		Version:       Version1,
+		Database:      "db",
+		FlushInterval: "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	if err := s.Send(m); err == nil || delivery.IsPermanent(err) {
+		t.Fatalf("Expected write to an unavailable server to fail temporarily but got %v\n", err)
+	}
+}
@@ -0,0 +1,147 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// maxRecordSize bounds the size of a single record, such as a spooled batch.
+const maxRecordSize = 64 * 1024 * 1024
+
+// Log is an append-only file of JSON records, one per line. It is replayed
+// when opened, and compacted by rewriting it with the records which are still
+// relevant. Callers synchronize their accesses.
+type Log struct {
+	path    string
+	file    *os.File
+	records int
+}
+
+// Open replays the log at the given path, passing each record to fn, and
+// opens it for appending. The log is created if it doesn't exist.
+func Open(path string, fn func(record []byte) error) (*Log, error) {
+	l := &Log{path: path}
+	if err := l.replay(fn); err != nil {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) replay(fn func(record []byte) error) error {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		// A truncated last record is expected after a crash.
+		if !json.Valid(scanner.Bytes()) {
+			break
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+		l.records++
+	}
+	return scanner.Err()
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// Records returns the number of records in the log, including the ones
+// which were superseded by later records.
+func (l *Log) Records() int {
+	return l.records
+}
+
+// Append writes the records at the end of the log.
+func (l *Log) Append(records ...interface{}) error {
+	w := bufio.NewWriter(l.file)
+	for _, r := range records {
+		if err := writeRecord(w, r); err != nil {
+			return err
+		}
+		l.records++
+	}
+	return w.Flush()
+}
+
+// Sync commits the appended records to stable storage.
+func (l *Log) Sync() error {
+	return l.file.Sync()
+}
+
+// Compact replaces the content of the log with the given records. The new
+// log is written aside and renamed over the old one, which is kept if that
+// fails.
+func (l *Log) Compact(records []interface{}) error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := l.rewrite(records); err != nil {
+		l.open()
+		return err
+	}
+	l.records = len(records)
+	return l.open()
+}
+
+func (l *Log) rewrite(records []interface{}) error {
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, r := range records {
+		if err := writeRecord(w, r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.path)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+func writeRecord(w *bufio.Writer, r interface{}) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
@@ -0,0 +1,91 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	N int `json:"n"`
+}
+
+func replay(t *testing.T, path string) (*Log, []int) {
+	var replayed []int
+	l, err := Open(path, func(b []byte) error {
+		var r record
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		replayed = append(replayed, r.N)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, replayed
+}
+
+func TestLogReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	l, _ := replay(t, path)
+	if err := l.Append(record{1}, record{2}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"n":`)
+	f.Close()
+
+	l, replayed := replay(t, path)
+	defer l.Close()
+	if len(replayed) != 2 || replayed[0] != 1 || replayed[1] != 2 || l.Records() != 2 {
+		t.Fatalf("Expected records [1 2] to be replayed but got %v\n", replayed)
+	}
+}
+
+func TestLogCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	l, _ := replay(t, path)
+	for i := 0; i < 10; i++ {
+		l.Append(record{i})
+	}
+	if err := l.Compact([]interface{}{record{9}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is still appended to once compacted.
+	if err := l.Append(record{10}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if b, _ := ioutil.ReadFile(path); strings.Count(string(b), "\n") != 2 {
+		t.Fatalf("Expected the compacted log to hold 2 lines but got:\n%s\n", b)
+	}
+
+	l, replayed := replay(t, path)
+	defer l.Close()
+	if len(replayed) != 2 || replayed[0] != 9 || replayed[1] != 10 {
+		t.Fatalf("Expected records [9 10] to be replayed but got %v\n", replayed)
+	}
+}
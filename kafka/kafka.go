@@ -0,0 +1,211 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = 5 * time.Second
+	defaultMaxRetryDelay = 15 * time.Minute
+
+	// commitTimeout bounds the commit of a handled message, which must go
+	// through even when the consumer is being stopped.
+	commitTimeout = 10 * time.Second
+
+	eventHeader = "X-GitHub-Event"
+)
+
+type Config struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+	GroupID string   `json:"group_id"`
+
+	// Messages which fail to be handled are retried with an exponential
+	// backoff from RetryDelay, up to MaxAttempts. The following messages of
+	// the partition wait meanwhile, so that events are handled in order.
+	MaxAttempts   int    `json:"max_attempts"`
+	RetryDelay    string `json:"retry_delay"`
+	MaxRetryDelay string `json:"max_retry_delay"`
+
+	// Undecodable messages, and those which exhausted their attempts, are
+	// published to the DeadLetterTopic, or dropped if there is none.
+	DeadLetterTopic string `json:"dead_letter_topic"`
+}
+
+type reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consumer reads events from a Kafka topic as a member of a consumer group.
+// The offset of a message is only committed once it was handled or given up
+// on, so that messages are redelivered if octostats stops in between.
+type Consumer struct {
+	retrier    delivery.Retrier
+	reader     reader
+	deadLetter writer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(config *Config, handler delivery.Handler) (*Consumer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("missing Kafka brokers")
+	}
+	if config.Topic == "" || config.GroupID == "" {
+		return nil, fmt.Errorf("missing Kafka topic or consumer group")
+	}
+
+	c := newConsumer(handler)
+	if config.MaxAttempts > 0 {
+		c.retrier.MaxAttempts = config.MaxAttempts
+	}
+	var err error
+	if config.RetryDelay != "" {
+		if c.retrier.Delay, err = time.ParseDuration(config.RetryDelay); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxRetryDelay != "" {
+		if c.retrier.MaxDelay, err = time.ParseDuration(config.MaxRetryDelay); err != nil {
+			return nil, err
+		}
+	}
+
+	c.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers: config.Brokers,
+		Topic:   config.Topic,
+		GroupID: config.GroupID,
+	})
+	if config.DeadLetterTopic != "" {
+		c.deadLetter = &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.DeadLetterTopic,
+			RequiredAcks: kafka.RequireAll,
+		}
+	}
+
+	go c.run()
+	return c, nil
+}
+
+func newConsumer(handler delivery.Handler) *Consumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Consumer{
+		retrier: delivery.Retrier{
+			Handler:     handler,
+			MaxAttempts: defaultMaxAttempts,
+			Delay:       defaultRetryDelay,
+			MaxDelay:    defaultMaxRetryDelay,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// Stop stops consuming messages, once the one being handled is, and leaves
+// the consumer group.
+func (c *Consumer) Stop() {
+	c.cancel()
+	<-c.done
+	if err := c.reader.Close(); err != nil {
+		log.Logger.Errorf("Failed to close Kafka reader: %v", err)
+	}
+	if c.deadLetter != nil {
+		c.deadLetter.Close()
+	}
+}
+
+func (c *Consumer) run() {
+	defer close(c.done)
+	for {
+		m, err := c.reader.FetchMessage(c.ctx)
+		if c.ctx.Err() != nil || err == io.EOF {
+			return
+		} else if err != nil {
+			log.Logger.Errorf("Failed to fetch Kafka message: %v", err)
+			if !delivery.Sleep(c.retrier.Delay, c.ctx.Done()) {
+				return
+			}
+			continue
+		}
+
+		if !c.handle(m) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+		if err := c.reader.CommitMessages(ctx, m); err != nil {
+			log.Logger.Errorf("Failed to commit Kafka message offset: %v", err)
+		}
+		cancel()
+	}
+}
+
+// handle passes the message to the consumer handler, retrying it with a
+// backoff on failure, and returns whether the message can be committed.
+// Retrying is pointless for some errors, and stops after the maximum number
+// of attempts, in which case the message is dead-lettered. It returns false
+// if the consumer was stopped first.
+func (c *Consumer) handle(m kafka.Message) bool {
+	fields := map[string]interface{}{"partition": m.Partition, "offset": m.Offset}
+	log.Logger.WithFields(fields).Debug("Queue event received")
+
+	switch err := c.retrier.Handle(eventType(m), m.Value, c.ctx.Done(), fields); err {
+	case nil:
+		return true
+	case delivery.ErrStopped:
+		return false
+	default:
+		log.Logger.WithFields(fields).Errorf("Giving up on message: %v", err)
+		return c.publishDeadLetter(m)
+	}
+}
+
+// publishDeadLetter publishes the message to the dead-letter topic, if any,
+// until it succeeds or the consumer is stopped.
+func (c *Consumer) publishDeadLetter(m kafka.Message) bool {
+	if c.deadLetter == nil {
+		return true
+	}
+	logger := log.Logger.WithField("partition", m.Partition).WithField("offset", m.Offset)
+	for {
+		err := c.deadLetter.WriteMessages(c.ctx, kafka.Message{Key: m.Key, Value: m.Value, Headers: m.Headers})
+		if err == nil {
+			return true
+		}
+		logger.Errorf("Dead-letter publication failed, retrying: %v", err)
+		if !delivery.Sleep(c.retrier.Delay, c.ctx.Done()) {
+			return false
+		}
+	}
+}
+
+// eventType returns the type of event carried in the message headers, if
+// the producer forwarded it.
+func eventType(m kafka.Message) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Key, eventHeader) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/delivery"
+	"github.com/segmentio/kafka-go"
+)
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "bad payload" }
+func (permanentError) Permanent() bool { return true }
+
+type recordingHandler struct {
+	errs   []error
+	events []string
+}
+
+func (h *recordingHandler) HandleEvent(event string, payload []byte) error {
+	h.events = append(h.events, event)
+	if len(h.errs) == 0 {
+		return nil
+	}
+	err := h.errs[0]
+	h.errs = h.errs[1:]
+	return err
+}
+
+type recordingWriter struct {
+	messages []kafka.Message
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *recordingWriter) Close() error { return nil }
+
+func testConsumer(h delivery.Handler) (*Consumer, *recordingWriter) {
+	w := &recordingWriter{}
+	c := newConsumer(h)
+	c.deadLetter = w
+	c.retrier.MaxAttempts = 3
+	c.retrier.Delay = time.Millisecond
+	c.retrier.MaxDelay = 2 * time.Millisecond
+	return c, w
+}
+
+func TestHandleRetry(t *testing.T) {
+	h := &recordingHandler{errs: []error{errors.New("store unavailable"), errors.New("store unavailable")}}
+	c, w := testConsumer(h)
+
+	m := kafka.Message{Value: []byte("{}"), Headers: []kafka.Header{{Key: "x-github-event", Value: []byte("push")}}}
+	if !c.handle(m) {
+		t.Fatal("Expected message to be committed")
+	}
+	if len(h.events) != 3 || h.events[2] != "push" {
+		t.Fatalf("Expected 3 attempts of a push event but got %v\n", h.events)
+	}
+	if len(w.messages) != 0 {
+		t.Fatal("Expected message not to be dead-lettered")
+	}
+}
+
+func TestHandleDeadLetter(t *testing.T) {
+	for _, tc := range []struct {
+		errs     []error
+		attempts int
+	}{
+		{[]error{permanentError{}}, 1},
+		{[]error{errors.New("a"), errors.New("b"), errors.New("c")}, 3},
+	} {
+		h := &recordingHandler{errs: tc.errs}
+		c, w := testConsumer(h)
+
+		if !c.handle(kafka.Message{Value: []byte("{}")}) {
+			t.Fatal("Expected message to be committed")
+		}
+		if len(h.events) != tc.attempts {
+			t.Fatalf("Expected %d attempts but got %d\n", tc.attempts, len(h.events))
+		}
+		if len(w.messages) != 1 || string(w.messages[0].Value) != "{}" {
+			t.Fatalf("Expected message to be dead-lettered but got %v\n", w.messages)
+		}
+	}
+}
+
+func TestHandleStopped(t *testing.T) {
+	c, _ := testConsumer(&recordingHandler{errs: []error{errors.New("store unavailable")}})
+	c.retrier.Delay = time.Hour
+	c.cancel()
+	if c.handle(kafka.Message{Value: []byte("{}")}) {
+		t.Fatal("Expected message not to be committed once stopped")
+	}
+}
@@ -1,34 +1,129 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/icecrime/octostats/cache"
 	"github.com/icecrime/octostats/config"
 	"github.com/icecrime/octostats/github"
+	"github.com/icecrime/octostats/graphite"
 	"github.com/icecrime/octostats/influx"
 	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/otlp"
+	"github.com/icecrime/octostats/prometheus"
 	"github.com/icecrime/octostats/repository"
+	"github.com/icecrime/octostats/spool"
+	"github.com/icecrime/octostats/sqlstore"
+	"github.com/icecrime/octostats/statsd"
 )
 
+type trackedRepository struct {
+	source    repository.Repository
+	frequency time.Duration
+}
+
 var (
-	source       repository.Repository
+	tracked      []trackedRepository
+	discoverers  []*discoverer
+	sched        = newScheduler()
+	ghClient     *github.Client
+	collectors   *metrics.Collectors
 	store        Store
 	globalConfig *config.Config
 )
 
+// defaultSource returns the repository that events without a repository are
+// attached to, or nil when only discovered repositories are tracked.
+func defaultSource() repository.Repository {
+	if len(tracked) == 0 {
+		return nil
+	}
+	return tracked[0].source
+}
+
+// newStore returns the configured output store, fanning out to every entry
+// of the `outputs` list when there is one.
 func newStore(c *config.Config) Store {
+	if len(c.Outputs) == 0 {
+		s, err := newOutput(c)
+		if err != nil {
+			log.Logger.Fatal(err)
+		}
+		return s
+	}
+
+	var outputs []namedStore
+	for i, o := range c.Outputs {
+		name := o.Name
+		if name == "" {
+			name = fmt.Sprintf("%s#%d", o.Type, i)
+		}
+		s, err := newOutput(c.ForOutput(o))
+		if err != nil {
+			log.Logger.Fatalf("Invalid output '%s': %v", name, err)
+		}
+		outputs = append(outputs, namedStore{name: name, store: s})
+	}
+	return newFanoutStore(outputs)
+}
+
+// newOutput returns the store of the configured output, spooling the metrics
+// which fail to be sent if the configuration says so.
+func newOutput(c *config.Config) (Store, error) {
+	s, err := newBackend(c)
+	if err != nil || c.SpoolConfig == nil {
+		return s, err
+	}
+	return spool.New(c.SpoolConfig, s)
+}
+
+func newBackend(c *config.Config) (Store, error) {
 	switch c.Output {
 	case "console":
-		return &debugStore{}
+		return &debugStore{}, nil
 	case "influxdb":
-		return influx.New(&c.InfluxDBConfig)
+		if v := c.InfluxDBConfig.Version; v == "" || v == influx.VersionLegacy {
+			return influx.New(&c.InfluxDBConfig)
+		}
+		return influx.NewLineStore(&c.InfluxDBConfig)
+	case "graphite":
+		return graphite.New(&c.GraphiteConfig)
+	case "statsd":
+		return statsd.New(&c.StatsDConfig)
+	case "dogstatsd":
+		return statsd.NewDogStatsD(&c.StatsDConfig)
+	case "otlp":
+		return otlp.New(&c.OTLPConfig)
+	case "sql":
+		return sqlstore.New(&c.SQLConfig)
+	case "prometheus":
+		return prometheus.New(&c.PrometheusConfig)
 	default:
-		log.Logger.Fatalf("Invalid output '%s'", c.Output)
-		return nil
+		return nil, fmt.Errorf("invalid output '%s'", c.Output)
 	}
 }
 
+func newTrackedRepositories(c *config.Config) ([]trackedRepository, error) {
+	var result []trackedRepository
+	for _, r := range c.TrackedRepositories() {
+		frequency, err := time.ParseDuration(r.UpdateFrequency)
+		if err != nil {
+			return nil, fmt.Errorf("bad update frequency for %s: %v", r.Name, err)
+		}
+
+		source, err := github.NewGitHubRepository(ghClient, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, trackedRepository{source: source, frequency: frequency})
+	}
+	return result, nil
+}
+
 func before(cli *cli.Context) error {
 	log.Configure(cli.String("loglevel"))
 	if len(cli.Args()) > 0 {
@@ -42,9 +137,42 @@ func before(cli *cli.Context) error {
 	}
 
 	store = newStore(globalConfig)
-	source, err = github.NewGitHubRepository(&globalConfig.GitHubConfig)
+	if collectors, err = metrics.NewCollectors(globalConfig.Collectors); err != nil {
+		return err
+	}
+	if ghClient, err = github.NewClient(&globalConfig.GitHubConfig); err != nil {
+		return err
+	}
+	if globalConfig.CacheConfig != nil {
+		if ghClient.Cache, err = cache.New(globalConfig.CacheConfig); err != nil {
+			return err
+		}
+	}
+	if tracked, err = newTrackedRepositories(globalConfig); err != nil {
+		return err
+	}
 
-	return err
+	switch globalConfig.EventsConfig.Untracked {
+	case "", untrackedDrop, untrackedCount:
+	default:
+		return fmt.Errorf("invalid untracked events policy '%s'", globalConfig.EventsConfig.Untracked)
+	}
+	if _, err := eventSourceType(globalConfig); err != nil {
+		return err
+	}
+
+	orgs := globalConfig.TrackedOrganizations()
+	if len(tracked) == 0 && len(orgs) == 0 {
+		return fmt.Errorf("no repository or organization configured")
+	}
+	for _, o := range orgs {
+		d, err := newDiscoverer(o, sched)
+		if err != nil {
+			return fmt.Errorf("bad configuration for organization %s: %v", o.Name, err)
+		}
+		discoverers = append(discoverers, d)
+	}
+	return nil
 }
 
 func main() {
@@ -52,7 +180,7 @@ func main() {
 	app.Action = mainCommand
 	app.Before = before
 	app.Name = "octostats"
-	app.Usage = "Extract metrics from a github repository"
+	app.Usage = "Extract metrics from GitHub repositories"
 
 	app.Flags = []cli.Flag{
 		cli.StringFlag{Name: "config", Value: "octostats.json", Usage: "configuration file"},
@@ -60,6 +188,6 @@ func main() {
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Logger.Fatalf(err.Error())
+		log.Logger.Fatal(err)
 	}
 }
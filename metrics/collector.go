@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/repository"
+	"github.com/octokit/go-octokit/octokit"
+)
+
+// Need describes a piece of repository data a collector works from: the
+// issues or pull requests in a given state, sorted by the given field.
+type Need struct {
+	Kind  string
+	State string
+	Sort  string
+}
+
+const (
+	IssuesKind       = "issues"
+	PullRequestsKind = "pull_requests"
+)
+
+var (
+	NeedOpenIssues         = Need{Kind: IssuesKind, State: "open", Sort: "updated"}
+	NeedClosedIssues       = Need{Kind: IssuesKind, State: "closed", Sort: "updated"}
+	NeedOpenPullRequests   = Need{Kind: PullRequestsKind, State: "open", Sort: "updated"}
+	NeedClosedPullRequests = Need{Kind: PullRequestsKind, State: "closed", Sort: "updated"}
+)
+
+// Collector computes metrics from repository data. The repository passed to
+// Collect only serves the data declared by Needs, which is retrieved once for
+// all collectors.
+type Collector interface {
+	Needs() []Need
+	Collect(repository.Repository) ([]Metric, error)
+}
+
+type funcCollector struct {
+	fn    func(repository.Repository) ([]Metric, error)
+	needs []Need
+}
+
+func (c *funcCollector) Needs() []Need {
+	return c.needs
+}
+
+func (c *funcCollector) Collect(r repository.Repository) ([]Metric, error) {
+	return c.fn(r)
+}
+
+// NewCollector makes a Collector from a function and the data it needs.
+func NewCollector(fn func(repository.Repository) ([]Metric, error), needs ...Need) Collector {
+	return &funcCollector{fn: fn, needs: needs}
+}
+
+// Factory creates a collector from its options in the configuration, which
+// are nil when none are given.
+type Factory func(options json.RawMessage) (Collector, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.Mutex
+)
+
+// Register makes a collector available by name. Registered collectors are
+// enabled unless the configuration says otherwise.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("metrics: collector %s registered twice", name))
+	}
+	registry[name] = factory
+}
+
+func init() {
+	for name, c := range map[string]Collector{
+		"opened_issues":        NewCollector(collectOpenedIssues, NeedOpenIssues),
+		"closed_issues":        NewCollector(collectClosedIssues, NeedClosedIssues),
+		"opened_pull_requests": NewCollector(collectOpenedPullRequests, NeedOpenPullRequests),
+		"closed_pull_requests": NewCollector(collectClosedPullRequests, NeedClosedPullRequests),
+	} {
+		c := c
+		Register(name, func(json.RawMessage) (Collector, error) { return c, nil })
+	}
+}
+
+// Collectors is the set of enabled collectors.
+type Collectors struct {
+	collectors map[string]Collector
+}
+
+// NewCollectors instantiates the registered collectors according to their
+// configuration.
+func NewCollectors(configs map[string]config.CollectorConfig) (*Collectors, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for name := range configs {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector '%s'", name)
+		}
+	}
+
+	result := &Collectors{collectors: make(map[string]Collector)}
+	for name, factory := range registry {
+		c := configs[name]
+		if c.Enabled != nil && !*c.Enabled {
+			continue
+		}
+
+		collector, err := factory(c.Options)
+		if err != nil {
+			return nil, fmt.Errorf("bad configuration for collector %s: %v", name, err)
+		}
+		result.collectors[name] = collector
+	}
+	return result, nil
+}
+
+// Names returns the sorted names of the enabled collectors.
+func (c *Collectors) Names() []string {
+	var names []string
+	for name := range c.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectorErrors reports the failure of some collectors, keyed by name.
+type CollectorErrors map[string]error
+
+func (e CollectorErrors) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e[name]))
+	}
+	return fmt.Sprintf("%d collectors failed (%s)", len(e), strings.Join(msgs, "; "))
+}
+
+// Retrieve computes all metrics for the repository using every registered
+// collector with its default options.
+func Retrieve(r repository.Repository) (*Metrics, error) {
+	c, err := NewCollectors(nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Retrieve(r)
+}
+
+// Retrieve computes the metrics of all enabled collectors for the repository.
+// The data they need is fetched once beforehand. Collectors which fail don't
+// prevent the others from contributing to the batch: their errors are
+// returned as CollectorErrors, and the batch is marked as partial. So is it if
+// any of the collectors worked from incomplete data, which is also reported
+// through the `collector.complete` metric.
+func (c *Collectors) Retrieve(r repository.Repository) (*Metrics, error) {
+	var needs []Need
+	for _, collector := range c.collectors {
+		needs = append(needs, collector.Needs()...)
+	}
+	data := fetchSnapshot(r, needs)
+
+	var (
+		waitGrp sync.WaitGroup
+		errsMu  sync.Mutex
+		errs    = make(CollectorErrors)
+	)
+	waitGrp.Add(len(c.collectors))
+
+	metrics := New(r)
+
+	for name, collector := range c.collectors {
+		go func(name string, collector Collector) {
+			defer waitGrp.Done()
+
+			items, err := collector.Collect(data.restrict(collector.Needs()))
+			if repository.IsPartial(err) {
+				log.Logger.WithField("repository", r.Nwo()).WithField("collector", name).Warn(err)
+				metrics.setPartial()
+			} else if err != nil {
+				metrics.setPartial()
+				errsMu.Lock()
+				errs[name] = err
+				errsMu.Unlock()
+				return
+			}
+			metrics.Add(items...)
+		}(name, collector)
+	}
+	waitGrp.Wait()
+
+	complete := 1
+	if metrics.Partial {
+		complete = 0
+	}
+	metrics.Add(NewMetric("collector.complete", map[string]interface{}{"count": complete}))
+
+	log.Logger.Debug("Retrieve: end")
+	if len(errs) > 0 {
+		return metrics, errs
+	}
+	return metrics, nil
+}
+
+type fetchResult struct {
+	issues       []octokit.Issue
+	pullRequests []octokit.PullRequest
+	err          error
+}
+
+// snapshot is a read-only repository serving data fetched beforehand.
+type snapshot struct {
+	nwo     string
+	results map[Need]*fetchResult
+	allowed map[Need]bool
+}
+
+func fetchSnapshot(r repository.Repository, needs []Need) *snapshot {
+	s := &snapshot{nwo: r.Nwo(), results: make(map[Need]*fetchResult)}
+	for _, n := range needs {
+		s.results[n] = &fetchResult{}
+	}
+
+	var waitGrp sync.WaitGroup
+	waitGrp.Add(len(s.results))
+	for n, res := range s.results {
+		go func(n Need, res *fetchResult) {
+			defer waitGrp.Done()
+
+			switch n.Kind {
+			case IssuesKind:
+				res.issues, res.err = r.Issues(n.State, n.Sort)
+			case PullRequestsKind:
+				res.pullRequests, res.err = r.PullRequests(n.State, n.Sort)
+			default:
+				res.err = fmt.Errorf("unknown data kind '%s'", n.Kind)
+			}
+		}(n, res)
+	}
+	waitGrp.Wait()
+
+	return s
+}
+
+// restrict returns a view of the snapshot only serving the given needs.
+func (s *snapshot) restrict(needs []Need) *snapshot {
+	allowed := make(map[Need]bool)
+	for _, n := range needs {
+		allowed[n] = true
+	}
+	return &snapshot{nwo: s.nwo, results: s.results, allowed: allowed}
+}
+
+func (s *snapshot) get(n Need) (*fetchResult, error) {
+	if !s.allowed[n] {
+		return nil, fmt.Errorf("undeclared need for %s %s sorted by %s", n.State, n.Kind, n.Sort)
+	}
+	return s.results[n], nil
+}
+
+func (s *snapshot) Nwo() string {
+	return s.nwo
+}
+
+func (s *snapshot) Issues(state, sort string) ([]octokit.Issue, error) {
+	res, err := s.get(Need{Kind: IssuesKind, State: state, Sort: sort})
+	if err != nil {
+		return nil, err
+	}
+	return res.issues, res.err
+}
+
+func (s *snapshot) PullRequests(state, sort string) ([]octokit.PullRequest, error) {
+	res, err := s.get(Need{Kind: PullRequestsKind, State: state, Sort: sort})
+	if err != nil {
+		return nil, err
+	}
+	return res.pullRequests, res.err
+}
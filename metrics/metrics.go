@@ -1,10 +1,10 @@
 package metrics
 
 import (
+	"reflect"
 	"sync"
 	"time"
 
-	"github.com/icecrime/octostats/log"
 	"github.com/icecrime/octostats/repository"
 	"github.com/octokit/go-octokit/octokit"
 )
@@ -12,7 +12,18 @@ import (
 type Metrics struct {
 	Origin repository.Repository
 	Items  []Metric
-	m      sync.Mutex
+
+	// Time is when the metrics were collected.
+	Time time.Time
+
+	// Events is set when the metrics count events which happened since the
+	// previous batch, rather than describe the state of the repository.
+	Events bool
+
+	// Partial is set when some of the metrics were computed from incomplete
+	// or stale repository data.
+	Partial bool
+	m       sync.Mutex
 }
 
 func (m *Metrics) Add(items ...Metric) {
@@ -21,13 +32,30 @@ func (m *Metrics) Add(items ...Metric) {
 	m.Items = append(m.Items, items...)
 }
 
+func (m *Metrics) setPartial() {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.Partial = true
+}
+
 func New(origin repository.Repository) *Metrics {
 	return &Metrics{
 		Origin: origin,
 		Items:  make([]Metric, 0),
+		Time:   time.Now(),
 	}
 }
 
+const (
+	// CountField holds the number of occurrences a metric stands for, which
+	// is 1 when the field is missing.
+	CountField = "count"
+
+	// ValueField holds a measurement, such as how long an event took, which
+	// unlike a count makes no sense summed.
+	ValueField = "value"
+)
+
 type Metric struct {
 	Path string
 	Data map[string]interface{}
@@ -37,6 +65,44 @@ func NewMetric(path string, data map[string]interface{}) Metric {
 	return Metric{Path: path, Data: data}
 }
 
+// Value returns the measurement of the metric if it is one, or its count.
+func (m Metric) Value() float64 {
+	if v, ok := Value(m.Data[ValueField]); ok {
+		return v
+	}
+	if v, ok := Value(m.Data[CountField]); ok {
+		return v
+	}
+	return 1
+}
+
+// IsMeasurement tells whether the metric is a measurement rather than a
+// count.
+func (m Metric) IsMeasurement() bool {
+	_, ok := Value(m.Data[ValueField])
+	return ok
+}
+
+// Value converts a metric field of any integer, floating point or boolean
+// type to a number, booleans counting as 0 or 1. Outputs use it so that all
+// agree on which fields are numeric.
+func Value(v interface{}) (float64, bool) {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
 func collectIssues(issues []octokit.Issue) []Metric {
 	var items []Metric
 	for _, i := range issues {
@@ -74,10 +140,10 @@ func collectPrs(pullRequests []octokit.PullRequest) []Metric {
 	return items
 }
 
-func collectOpenedPullRequests(r repository.Repository) []Metric {
+func collectOpenedPullRequests(r repository.Repository) ([]Metric, error) {
 	pullRequests, err := r.PullRequests("open", "updated")
-	if err != nil {
-		log.Logger.Fatalf(err.Error())
+	if err != nil && !repository.IsPartial(err) {
+		return nil, err
 	}
 
 	var items []Metric
@@ -90,63 +156,38 @@ func collectOpenedPullRequests(r repository.Repository) []Metric {
 		items = append(items, NewMetric("pull_requests.least_recently_updated_days", map[string]interface{}{"count": value}))
 	}
 
-	return items
+	return items, err
 }
 
-func collectClosedPullRequests(r repository.Repository) []Metric {
+func collectClosedPullRequests(r repository.Repository) ([]Metric, error) {
 	pullRequests, err := r.PullRequests("closed", "updated")
-	if err != nil {
-		log.Logger.Fatalf(err.Error())
+	if err != nil && !repository.IsPartial(err) {
+		return nil, err
 	}
 	var items []Metric
 	items = append(items, NewMetric("pull_requests.closed", map[string]interface{}{"count": len(pullRequests)}))
 	items = append(items, collectPrs(pullRequests)...)
-	return items
+	return items, err
 }
 
-func collectOpenedIssues(r repository.Repository) []Metric {
+func collectOpenedIssues(r repository.Repository) ([]Metric, error) {
 	issues, err := r.Issues("open", "updated")
-	if err != nil {
-		log.Logger.Fatalf(err.Error())
+	if err != nil && !repository.IsPartial(err) {
+		return nil, err
 	}
 	var items []Metric
 	items = append(items, NewMetric("issues.open", map[string]interface{}{"count": len(issues)}))
 	items = append(items, collectIssues(issues)...)
-	return items
+	return items, err
 }
 
-func collectClosedIssues(r repository.Repository) []Metric {
+func collectClosedIssues(r repository.Repository) ([]Metric, error) {
 	issues, err := r.Issues("closed", "updated")
-	if err != nil {
-		log.Logger.Fatalf(err.Error())
+	if err != nil && !repository.IsPartial(err) {
+		return nil, err
 	}
 	var items []Metric
 	items = append(items, NewMetric("issues.closed", map[string]interface{}{"count": len(issues)}))
 	items = append(items, collectIssues(issues)...)
-	return items
-}
-
-func Retrieve(r repository.Repository) *Metrics {
-	tasks := []func(repository.Repository) []Metric{
-		collectOpenedIssues,
-		collectClosedIssues,
-		collectOpenedPullRequests,
-		collectClosedPullRequests,
-	}
-
-	var waitGrp sync.WaitGroup
-	waitGrp.Add(len(tasks))
-
-	metrics := New(r)
-
-	for _, fn := range tasks {
-		go func(fn func(repository.Repository) []Metric) {
-			defer waitGrp.Done()
-			metrics.Add(fn(r)...)
-		}(fn)
-	}
-	waitGrp.Wait()
-
-	log.Logger.Debug("Retrieve: end")
-	return metrics
+	return items, err
 }
@@ -1,10 +1,15 @@
 package metrics
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/icecrime/octostats/config"
 	"github.com/icecrime/octostats/fixtures"
 	"github.com/icecrime/octostats/github"
+	"github.com/icecrime/octostats/repository"
+	"github.com/octokit/go-octokit/octokit"
 )
 
 func TestCollectIssues(t *testing.T) {
@@ -13,10 +18,146 @@ func TestCollectIssues(t *testing.T) {
 	defer fixtures.TearDown()
 
 	r := github.NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
-	items := collectOpenedIssues(r)
+	items, err := collectOpenedIssues(r)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// 1 global counter + 4 issues + 4 labels
 	if len(items) != 9 {
 		t.Fatalf("Expected 8 metrics but got %d\n", len(items))
 	}
 }
+
+type failingIssuesRepository struct {
+	repository.Repository
+}
+
+func (failingIssuesRepository) Issues(string, string) ([]octokit.Issue, error) {
+	return nil, errors.New("issues unavailable")
+}
+
+func TestRetrievePartialFailure(t *testing.T) {
+	fixtures.Setup()
+	fixtures.SetupMux(t, "pulls")
+	defer fixtures.TearDown()
+
+	r := failingIssuesRepository{github.NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)}
+	m, err := Retrieve(r)
+
+	errs, ok := err.(CollectorErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected 2 collector errors but got %v\n", err)
+	}
+	if _, ok := errs["opened_issues"]; !ok {
+		t.Fatalf("Expected opened_issues collector to fail\n")
+	}
+	if !m.Partial {
+		t.Fatalf("Expected metrics to be marked as partial\n")
+	}
+
+	// 2 counters + 4 open pull requests + 1 least recently updated + 1 completion
+	if len(m.Items) != 8 {
+		t.Fatalf("Expected 8 metrics but got %d\n", len(m.Items))
+	}
+}
+
+// unregister removes a collector registered by a test, so that it can run
+// again and doesn't leak into the default set of other tests.
+func unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+func TestCustomCollector(t *testing.T) {
+	fixtures.Setup()
+	fixtures.SetupMux(t, "pulls")
+	defer fixtures.TearDown()
+
+	defer unregister("test_custom")
+	Register("test_custom", func(options json.RawMessage) (Collector, error) {
+		var threshold int
+		if err := json.Unmarshal(options, &threshold); err != nil {
+			return nil, err
+		}
+
+		return NewCollector(func(r repository.Repository) ([]Metric, error) {
+			prs, err := r.PullRequests("open", "updated")
+			return []Metric{NewMetric("custom", map[string]interface{}{"count": len(prs) - threshold})}, err
+		}, NeedOpenPullRequests), nil
+	})
+
+	disabled := false
+	collectors, err := NewCollectors(map[string]config.CollectorConfig{
+		"test_custom":   {Options: json.RawMessage("1")},
+		"opened_issues": {Enabled: &disabled},
+		"closed_issues": {Enabled: &disabled},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := github.NewGitHubRepositoryWithClient("docker", "docker", fixtures.Client)
+	m, err := collectors.Retrieve(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range m.Items {
+		if item.Path == "custom" {
+			if count := item.Data["count"]; count != 3 {
+				t.Fatalf("Expected custom count to be 3 but got %v\n", count)
+			}
+			return
+		}
+	}
+	t.Fatalf("Expected custom metric to be collected\n")
+}
+
+func TestUnknownCollector(t *testing.T) {
+	if _, err := NewCollectors(map[string]config.CollectorConfig{"unknown": {}}); err == nil {
+		t.Fatalf("Expected unknown collector to be rejected\n")
+	}
+}
+
+func TestValue(t *testing.T) {
+	for _, tc := range []struct {
+		field    interface{}
+		expected float64
+		ok       bool
+	}{
+		{3, 3, true},
+		{int64(4), 4, true},
+		{uint32(5), 5, true},
+		{1.5, 1.5, true},
+		{true, 1, true},
+		{false, 0, true},
+		{"6", 0, false},
+		{nil, 0, false},
+	} {
+		if value, ok := Value(tc.field); value != tc.expected || ok != tc.ok {
+			t.Fatalf("Expected %v to convert to %v (%v) but got %v (%v)\n", tc.field, tc.expected, tc.ok, value, ok)
+		}
+	}
+}
+
+func TestMetricValue(t *testing.T) {
+	for _, tc := range []struct {
+		data        map[string]interface{}
+		expected    float64
+		measurement bool
+	}{
+		{map[string]interface{}{"state": "open"}, 1, false},
+		{map[string]interface{}{"count": int64(3)}, 3, false},
+		{map[string]interface{}{"value": 12}, 12, true},
+	} {
+		m := NewMetric("test", tc.data)
+		if value := m.Value(); value != tc.expected {
+			t.Fatalf("Expected value %v of %v but got %v\n", tc.expected, tc.data, value)
+		}
+		if m.IsMeasurement() != tc.measurement {
+			t.Fatalf("Expected %v to be a measurement: %v\n", tc.data, tc.measurement)
+		}
+	}
+}
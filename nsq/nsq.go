@@ -1,27 +1,221 @@
 package nsq
 
-import "github.com/bitly/go-nsq"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+)
+
+const (
+	defaultMaxAttempts     = 5
+	defaultRequeueDelay    = 5 * time.Second
+	defaultMaxRequeueDelay = 15 * time.Minute
+)
 
 type Config struct {
 	Topic      string `json:"topic"`
 	Channel    string `json:"channel"`
 	LookupAddr string `json:"lookup_address"`
+
+	LookupAddrs []string `json:"lookup_addresses"`
+	NSQDAddrs   []string `json:"nsqd_addresses"`
+	MaxInFlight int      `json:"max_in_flight"`
+
+	// Messages which fail to be handled are requeued with an exponential
+	// backoff from RequeueDelay, up to MaxAttempts.
+	MaxAttempts     uint16 `json:"max_attempts"`
+	RequeueDelay    string `json:"requeue_delay"`
+	MaxRequeueDelay string `json:"max_requeue_delay"`
+
+	// Undecodable messages, and those which exhausted their attempts, are
+	// published to the DeadLetterTopic of the nsqd at DeadLetterAddr (by
+	// default the first of NSQDAddrs), or dropped if there is none.
+	DeadLetterTopic string `json:"dead_letter_topic"`
+	DeadLetterAddr  string `json:"dead_letter_address"`
+
+	TLS        *TLSConfig `json:"tls,omitempty"`
+	AuthSecret string     `json:"auth_secret"`
 }
 
-func New(config *Config, handler nsq.Handler) (*Queue, error) {
-	consumer, err := nsq.NewConsumer(config.Topic, config.Channel, nsq.NewConfig())
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+func New(config *Config, handler delivery.Handler) (*Queue, error) {
+	c, err := consumerConfig(config)
 	if err != nil {
 		return nil, err
 	}
-
-	consumer.AddHandler(handler)
-	if err := consumer.ConnectToNSQLookupd(config.LookupAddr); err != nil {
+	consumer, err := nsq.NewConsumer(config.Topic, config.Channel, c)
+	if err != nil {
 		return nil, err
 	}
 
-	return &Queue{Consumer: consumer}, nil
+	q := &Queue{
+		Consumer: consumer,
+		retrier: delivery.Retrier{
+			Handler:     handler,
+			MaxAttempts: int(config.MaxAttempts),
+			Delay:       defaultRequeueDelay,
+			MaxDelay:    defaultMaxRequeueDelay,
+		},
+		deadLetterTopic: config.DeadLetterTopic,
+	}
+	if q.retrier.MaxAttempts == 0 {
+		q.retrier.MaxAttempts = defaultMaxAttempts
+	}
+	if config.RequeueDelay != "" {
+		if q.retrier.Delay, err = time.ParseDuration(config.RequeueDelay); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxRequeueDelay != "" {
+		if q.retrier.MaxDelay, err = time.ParseDuration(config.MaxRequeueDelay); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DeadLetterTopic != "" {
+		addr := config.DeadLetterAddr
+		if addr == "" && len(config.NSQDAddrs) > 0 {
+			addr = config.NSQDAddrs[0]
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("missing nsqd address for the dead-letter topic")
+		}
+		if q.producer, err = nsq.NewProducer(addr, c); err != nil {
+			return nil, err
+		}
+	}
+
+	concurrency := c.MaxInFlight
+	consumer.AddConcurrentHandlers(q, concurrency)
+
+	lookupAddrs := config.LookupAddrs
+	if config.LookupAddr != "" {
+		lookupAddrs = append([]string{config.LookupAddr}, lookupAddrs...)
+	}
+	if len(lookupAddrs) == 0 && len(config.NSQDAddrs) == 0 {
+		return nil, fmt.Errorf("missing nsqlookupd or nsqd addresses")
+	}
+	if len(lookupAddrs) > 0 {
+		if err := consumer.ConnectToNSQLookupds(lookupAddrs); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.NSQDAddrs) > 0 {
+		if err := consumer.ConnectToNSQDs(config.NSQDAddrs); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func consumerConfig(config *Config) (*nsq.Config, error) {
+	c := nsq.NewConfig()
+	if config.MaxInFlight > 0 {
+		c.MaxInFlight = config.MaxInFlight
+	}
+	c.AuthSecret = config.AuthSecret
+
+	// Attempts are accounted by the queue handler, so that exhausted messages
+	// go to the dead-letter topic.
+	c.MaxAttempts = 0
+
+	if config.TLS != nil {
+		tlsConfig, err := newTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		c.TlsV1 = true
+		c.TlsConfig = tlsConfig
+	}
+	return c, c.Validate()
+}
+
+func newTLSConfig(c *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
 type Queue struct {
 	Consumer *nsq.Consumer
+
+	retrier         delivery.Retrier
+	producer        *nsq.Producer
+	deadLetterTopic string
+}
+
+// Stop stops consuming messages, once those being handled are, and releases
+// the connection to the dead-letter nsqd.
+func (q *Queue) Stop() {
+	q.Consumer.Stop()
+	<-q.Consumer.StopChan
+	if q.producer != nil {
+		q.producer.Stop()
+	}
+}
+
+// HandleMessage passes the message to the queue handler. Failed messages are
+// requeued with a backoff, unless retrying them is pointless or they reached
+// the maximum number of attempts, in which case they are dead-lettered.
+func (q *Queue) HandleMessage(m *nsq.Message) error {
+	m.DisableAutoResponse()
+	logger := log.Logger.WithField("message", string(m.ID[:])).WithField("attempts", m.Attempts)
+	logger.Debug("Queue event received")
+
+	err := q.retrier.Handler.HandleEvent("", m.Body)
+	if err == nil {
+		m.Finish()
+		return nil
+	}
+
+	if delivery.IsPermanent(err) || int(m.Attempts) >= q.retrier.MaxAttempts {
+		logger.Errorf("Giving up on message: %v", err)
+		if err := q.deadLetter(m); err != nil {
+			logger.Errorf("Dead-letter publication failed, requeuing: %v", err)
+			m.Requeue(q.retrier.MaxDelay)
+			return nil
+		}
+		m.Finish()
+		return nil
+	}
+
+	delay := q.retrier.Backoff(int(m.Attempts))
+	logger.Warnf("Requeuing message in %v: %v", delay, err)
+	m.Requeue(delay)
+	return nil
+}
+
+func (q *Queue) deadLetter(m *nsq.Message) error {
+	if q.producer == nil {
+		return nil
+	}
+	return q.producer.Publish(q.deadLetterTopic, m.Body)
 }
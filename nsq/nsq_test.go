@@ -0,0 +1,75 @@
+package nsq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/icecrime/octostats/delivery"
+)
+
+type recordingDelegate struct {
+	finished bool
+	requeued time.Duration
+}
+
+func (d *recordingDelegate) OnFinish(*nsq.Message) { d.finished = true }
+func (d *recordingDelegate) OnTouch(*nsq.Message)  {}
+func (d *recordingDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	d.requeued = delay
+}
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "bad payload" }
+func (permanentError) Permanent() bool { return true }
+
+type failingHandler struct {
+	err error
+}
+
+func (h failingHandler) HandleEvent(string, []byte) error {
+	return h.err
+}
+
+func handle(q *Queue, attempts uint16) *recordingDelegate {
+	d := &recordingDelegate{}
+	m := nsq.NewMessage(nsq.MessageID{}, []byte("{}"))
+	m.Delegate = d
+	m.Attempts = attempts
+	q.HandleMessage(m)
+	return d
+}
+
+func TestHandleMessageRequeue(t *testing.T) {
+	q := &Queue{retrier: delivery.Retrier{
+		Handler:     failingHandler{errors.New("store unavailable")},
+		MaxAttempts: 3,
+		Delay:       time.Second,
+		MaxDelay:    3 * time.Second,
+	}}
+
+	for _, tc := range []struct {
+		attempts uint16
+		delay    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+	} {
+		if d := handle(q, tc.attempts); d.finished || d.requeued != tc.delay {
+			t.Fatalf("Expected attempt %d to be requeued in %v but got %v\n", tc.attempts, tc.delay, d.requeued)
+		}
+	}
+
+	if d := handle(q, 3); !d.finished {
+		t.Fatal("Expected message to be given up on after the maximum attempts")
+	}
+}
+
+func TestHandleMessagePermanentError(t *testing.T) {
+	q := &Queue{retrier: delivery.Retrier{Handler: failingHandler{permanentError{}}, MaxAttempts: 3}}
+	if d := handle(q, 1); !d.finished {
+		t.Fatal("Expected undecodable message not to be retried")
+	}
+}
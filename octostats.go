@@ -3,63 +3,124 @@ package main
 import (
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/icecrime/octostats/github"
 	"github.com/icecrime/octostats/log"
 	"github.com/icecrime/octostats/metrics"
-	"github.com/icecrime/octostats/nsq"
+	"github.com/icecrime/octostats/repository"
+	"github.com/icecrime/octostats/webhook"
 
 	"github.com/codegangsta/cli"
 )
 
-func updateTicker() *time.Ticker {
-	duration, err := time.ParseDuration(globalConfig.UpdateFrequency)
-	if err != nil {
-		log.Logger.Fatal(err)
+func onTimerTick(source repository.Repository) {
+	log.Logger.WithField("repository", source.Nwo()).Debug("Tick: fetching statistics")
+	stats, err := collectors.Retrieve(source)
+	if errs, ok := err.(metrics.CollectorErrors); ok {
+		log.Logger.WithField("repository", source.Nwo()).Error(err)
+		for name := range errs {
+			stats.Add(metrics.NewMetric("collector.errors", map[string]interface{}{
+				"count":     1,
+				"collector": name,
+			}))
+		}
+	}
+
+	if err := store.Send(stats); err != nil {
+		log.Logger.Error(err)
 	}
-	return time.NewTicker(duration)
 }
 
-func onTimerTick() {
-	log.Logger.Debug("Tick: fetching statistics")
-	stats := metrics.Retrieve(source)
+// tokensOrigin is the origin of the token pool metrics. The pool is shared by
+// all repositories, so its state is reported once rather than with each.
+var tokensOrigin = repository.Named("github")
+
+func onTokensTick(pool *github.TokenPool) {
+	stats := metrics.New(tokensOrigin)
+	for _, t := range pool.Stats() {
+		stats.Add(metrics.NewMetric("github.tokens", map[string]interface{}{
+			"token":    strconv.Itoa(t.Index),
+			"count":    t.Remaining,
+			"revoked":  t.Revoked,
+			"requests": t.Requests,
+		}))
+	}
+
 	if err := store.Send(stats); err != nil {
 		log.Logger.Error(err)
 	}
 }
 
+// tokensFrequency returns how often to report the state of the token pool:
+// as often as the most frequently collected repositories.
+func tokensFrequency() time.Duration {
+	var frequency time.Duration
+	for _, t := range tracked {
+		if frequency == 0 || t.frequency < frequency {
+			frequency = t.frequency
+		}
+	}
+	for _, d := range discoverers {
+		if frequency == 0 || d.frequency < frequency {
+			frequency = d.frequency
+		}
+	}
+	return frequency
+}
+
+// startCollection schedules the tracked repositories and completes a first
+// discovery of the organizations, so that events are routed to them as soon
+// as they are received. Discovery then goes on until stop is closed.
+func startCollection(stop chan struct{}) {
+	for _, t := range tracked {
+		sched.Add(t.source, t.frequency)
+	}
+	for _, d := range discoverers {
+		d.sync()
+		go d.run(stop)
+	}
+	if pool := ghClient.Tokens; pool != nil {
+		sched.Every(tokensFrequency(), func() { onTokensTick(pool) })
+	}
+}
+
 func mainCommand(cli *cli.Context) {
 	s := make(chan os.Signal, 64)
 	signal.Notify(s, syscall.SIGTERM, syscall.SIGINT)
 
-	var queue *nsq.Queue
-	var queueStopChan chan int
+	discoveryStop := make(chan struct{})
+	startCollection(discoveryStop)
 
-	if globalConfig.NSQConfig != nil {
-		var err error
-		queue, err = nsq.New(globalConfig.NSQConfig, NewNSQHandler())
-		if err != nil {
+	source, err := newEventSource(globalConfig, NewEventHandler())
+	if err != nil {
+		log.Logger.Fatal(err)
+	}
+
+	var server *webhook.Server
+	if globalConfig.WebhookConfig != nil {
+		if server, err = webhook.New(globalConfig.WebhookConfig, NewEventHandler()); err != nil {
 			log.Logger.Fatal(err)
 		}
-		queueStopChan = queue.Consumer.StopChan
-	} else {
-		queueStopChan = make(chan int, 1)
 	}
 
-	ticker := updateTicker()
-	for {
-		select {
-		case <-ticker.C:
-			onTimerTick()
-		case <-queueStopChan:
-			log.Logger.Debug("Queue stop channel signaled")
-			return
-		case sig := <-s:
-			log.Logger.WithField("signal", sig).Debug("received signal")
-			if queue != nil {
-				queue.Consumer.Stop()
-			}
-		}
+	sig := <-s
+	log.Logger.WithField("signal", sig).Debug("received signal")
+
+	// Stop producing metrics before closing the stores they are sent to.
+	if source != nil {
+		source.Stop()
+	}
+	if server != nil {
+		server.Close()
+	}
+	close(discoveryStop)
+	sched.Stop()
+
+	closeStore(store)
+	if ghClient.Cache != nil {
+		ghClient.Cache.Close()
 	}
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/github"
+)
+
+func TestTokensTick(t *testing.T) {
+	client, err := github.NewClient(&config.GitHubConfig{AuthTokens: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &recordingStore{}
+	store = recorder
+	onTokensTick(client.Tokens)
+
+	if len(recorder.sent) != 1 || recorder.sent[0].Origin != tokensOrigin {
+		t.Fatalf("Expected a single batch of token metrics but got %v\n", recorder.sent)
+	}
+	if items := recorder.sent[0].Items; len(items) != 2 || items[0].Path != "github.tokens" {
+		t.Fatalf("Expected a metric per token but got %v\n", items)
+	}
+}
@@ -0,0 +1,177 @@
+package otlp
+
+import (
+	"math"
+)
+
+// The types below are the subset of the OTLP metrics data model which is
+// needed to export gauges and sums. Their JSON encoding follows the OTLP/HTTP JSON
+// mapping, and the protobuf encoding the field numbers of the
+// opentelemetry-proto definitions.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// temporalityCumulative is the AGGREGATION_TEMPORALITY_CUMULATIVE value of
+// sums which report a total since their start time.
+const temporalityCumulative = 2
+
+type metric struct {
+	Name  string `json:"name"`
+	Gauge *gauge `json:"gauge,omitempty"`
+	Sum   *sum   `json:"sum,omitempty"`
+}
+
+// dataPoints returns the data points of the gauge or sum.
+func (m *metric) dataPoints() *[]dataPoint {
+	if m.Sum != nil {
+		return &m.Sum.DataPoints
+	}
+	return &m.Gauge.DataPoints
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+	AsInt             *int64     `json:"asInt,string,omitempty"`
+}
+
+// setValue sets the value of the data point, as an integer when it is one.
+func (dp *dataPoint) setValue(v float64) {
+	if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+		i := int64(v)
+		dp.AsInt, dp.AsDouble = &i, nil
+		return
+	}
+	dp.AsInt, dp.AsDouble = nil, &v
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (r *exportRequest) marshalProto() []byte {
+	var b protoBuffer
+	for _, rm := range r.ResourceMetrics {
+		b.message(1, rm.marshalProto())
+	}
+	return b.bytes()
+}
+
+func (rm *resourceMetrics) marshalProto() []byte {
+	var b protoBuffer
+	b.message(1, rm.Resource.marshalProto())
+	for _, sm := range rm.ScopeMetrics {
+		b.message(2, sm.marshalProto())
+	}
+	return b.bytes()
+}
+
+func (r *resource) marshalProto() []byte {
+	var b protoBuffer
+	for _, kv := range r.Attributes {
+		b.message(1, kv.marshalProto())
+	}
+	return b.bytes()
+}
+
+func (sm *scopeMetrics) marshalProto() []byte {
+	var b protoBuffer
+	var s protoBuffer
+	s.string(1, sm.Scope.Name)
+	s.string(2, sm.Scope.Version)
+	b.message(1, s.bytes())
+	for _, m := range sm.Metrics {
+		b.message(2, m.marshalProto())
+	}
+	return b.bytes()
+}
+
+func (m *metric) marshalProto() []byte {
+	var d protoBuffer
+	for _, dp := range *m.dataPoints() {
+		d.message(1, dp.marshalProto())
+	}
+
+	var b protoBuffer
+	b.string(1, m.Name)
+	if m.Sum != nil {
+		d.uint(2, uint64(m.Sum.AggregationTemporality))
+		d.bool(3, m.Sum.IsMonotonic)
+		b.message(7, d.bytes())
+	} else {
+		b.message(5, d.bytes())
+	}
+	return b.bytes()
+}
+
+func (dp *dataPoint) marshalProto() []byte {
+	var b protoBuffer
+	if dp.StartTimeUnixNano != 0 {
+		b.fixed64(2, dp.StartTimeUnixNano)
+	}
+	b.fixed64(3, dp.TimeUnixNano)
+	if dp.AsDouble != nil {
+		b.fixed64(4, math.Float64bits(*dp.AsDouble))
+	}
+	if dp.AsInt != nil {
+		b.fixed64(6, uint64(*dp.AsInt))
+	}
+	for _, kv := range dp.Attributes {
+		b.message(7, kv.marshalProto())
+	}
+	return b.bytes()
+}
+
+func (kv *keyValue) marshalProto() []byte {
+	var v protoBuffer
+	switch {
+	case kv.Value.StringValue != nil:
+		v.forceString(1, *kv.Value.StringValue)
+	case kv.Value.BoolValue != nil:
+		v.bool(2, *kv.Value.BoolValue)
+	}
+
+	var b protoBuffer
+	b.string(1, kv.Key)
+	b.message(2, v.bytes())
+	return b.bytes()
+}
@@ -0,0 +1,244 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	defaultEndpoint = "http://localhost:4318/v1/metrics"
+	scopeName       = "github.com/icecrime/octostats"
+	requestTimeout  = 30 * time.Second
+
+	// EncodingProtobuf is the default binary OTLP/HTTP encoding.
+	EncodingProtobuf = "protobuf"
+	// EncodingJSON is the OTLP/HTTP JSON encoding.
+	EncodingJSON = "json"
+)
+
+// New returns a store exporting metrics as OTLP gauges, and event counts as
+// cumulative sums, to the configured collector endpoint.
+func New(c *config.OTLPConfig) (*store, error) {
+	s := &store{
+		endpoint:   c.Endpoint,
+		encoding:   c.Encoding,
+		headers:    c.Headers,
+		attributes: c.ResourceAttributes,
+		client:     &http.Client{Timeout: requestTimeout},
+		start:      time.Now(),
+		totals:     make(map[string]float64),
+	}
+	if s.endpoint == "" {
+		s.endpoint = defaultEndpoint
+	}
+	if s.encoding == "" {
+		s.encoding = EncodingProtobuf
+	}
+	if s.encoding != EncodingProtobuf && s.encoding != EncodingJSON {
+		return nil, fmt.Errorf("unsupported OTLP encoding '%s'", s.encoding)
+	}
+	return s, nil
+}
+
+type store struct {
+	endpoint   string
+	encoding   string
+	headers    map[string]string
+	attributes map[string]string
+	client     *http.Client
+
+	// totals are the cumulative sums of the event counts since start, keyed
+	// by repository, path and attributes. Event batches are sent one at a
+	// time, so that totals are only updated once exported.
+	start  time.Time
+	totals map[string]float64
+	m      sync.Mutex
+}
+
+func (s *store) Send(m *metrics.Metrics) error {
+	if m.Events {
+		s.m.Lock()
+		defer s.m.Unlock()
+	}
+
+	req, totals := s.convert(m, m.Time)
+	if err := s.export(m, req); err != nil {
+		return err
+	}
+	for key, total := range totals {
+		s.totals[key] = total
+	}
+	return nil
+}
+
+func (s *store) export(m *metrics.Metrics, req *exportRequest) error {
+	var body []byte
+	contentType := "application/x-protobuf"
+	if s.encoding == EncodingJSON {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	} else {
+		body = req.marshalProto()
+	}
+
+	httpReq, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	for k, v := range s.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	log.Logger.Debugf("Exporting %d metrics for %s", len(m.Items), m.Origin.Nwo())
+	res, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		err := fmt.Errorf("OTLP export failed with status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+		if delivery.RejectedStatus(res.StatusCode) {
+			return delivery.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// convert builds the export request of the metrics: the repository is a
+// resource attribute, and each metric path a gauge with one data point per set
+// of attributes. The value of a data point is the `value` or `count` field of
+// the metric, or the number of items sharing the same attributes.
+//
+// Event counts are sums instead, which data points hold the total since the
+// store started: the new totals are returned, to be kept once exported.
+func (s *store) convert(m *metrics.Metrics, now time.Time) (*exportRequest, map[string]float64) {
+	var gauges []*metric
+	index := make(map[string]*metric)
+	points := make(map[string]*point)
+	for _, item := range m.Items {
+		counter := m.Events && !item.IsMeasurement()
+		g, ok := index[item.Path]
+		if !ok {
+			g = &metric{Name: item.Path}
+			if counter {
+				g.Sum = &sum{AggregationTemporality: temporalityCumulative, IsMonotonic: true}
+			} else {
+				g.Gauge = &gauge{}
+			}
+			index[item.Path] = g
+			gauges = append(gauges, g)
+		}
+
+		attributes, key, value := itemAttributes(item)
+		key = m.Origin.Nwo() + "|" + item.Path + "|" + key
+		if p, ok := points[key]; ok {
+			if item.IsMeasurement() {
+				p.value = value
+			} else {
+				p.value += value
+			}
+			continue
+		}
+
+		dataPoints := g.dataPoints()
+		points[key] = &point{metric: g, index: len(*dataPoints), value: value}
+		*dataPoints = append(*dataPoints, dataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: uint64(now.UnixNano()),
+		})
+	}
+
+	totals := make(map[string]float64)
+	for key, p := range points {
+		dp := &(*p.metric.dataPoints())[p.index]
+		if p.metric.Sum != nil {
+			totals[key] = s.totals[key] + p.value
+			p.value = totals[key]
+			dp.StartTimeUnixNano = uint64(s.start.UnixNano())
+		}
+		dp.setValue(p.value)
+	}
+
+	sm := scopeMetrics{Scope: scope{Name: scopeName}}
+	for _, g := range gauges {
+		sm.Metrics = append(sm.Metrics, *g)
+	}
+
+	resourceAttributes := map[string]interface{}{
+		"service.name": "octostats",
+		"repository":   m.Origin.Nwo(),
+	}
+	for k, v := range s.attributes {
+		resourceAttributes[k] = v
+	}
+	attributes, _ := sortedAttributes(resourceAttributes)
+
+	return &exportRequest{
+		ResourceMetrics: []resourceMetrics{{
+			Resource:     resource{Attributes: attributes},
+			ScopeMetrics: []scopeMetrics{sm},
+		}},
+	}, totals
+}
+
+// point locates a data point while its value is summed.
+type point struct {
+	metric *metric
+	index  int
+	value  float64
+}
+
+// itemAttributes returns the string and boolean fields of a metric as data
+// point attributes, a key identifying them, and the value of the metric.
+func itemAttributes(item metrics.Metric) ([]keyValue, string, float64) {
+	fields := make(map[string]interface{})
+	for k, v := range item.Data {
+		switch v.(type) {
+		case string, bool:
+			fields[k] = v
+		}
+	}
+	attributes, key := sortedAttributes(fields)
+	return attributes, key, item.Value()
+}
+
+func sortedAttributes(fields map[string]interface{}) ([]keyValue, string) {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var attributes []keyValue
+	var key bytes.Buffer
+	for _, k := range keys {
+		kv := keyValue{Key: k}
+		switch v := fields[k].(type) {
+		case string:
+			kv.Value.StringValue = &v
+		case bool:
+			kv.Value.BoolValue = &v
+		}
+		attributes = append(attributes, kv)
+		fmt.Fprintf(&key, "%s=%v,", k, fields[k])
+	}
+	return attributes, key.String()
+}
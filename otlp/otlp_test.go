@@ -0,0 +1,145 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestExportJSON(t *testing.T) {
+	var contentType string
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	s, err := New(&config.OTLPConfig{Endpoint: ts.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1347}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1348}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Fatalf("Unexpected content type %s\n", contentType)
+	}
+	for _, expected := range []string{
+		`{"key":"repository","value":{"stringValue":"docker.docker"}}`,
+		`"name":"issues.open","gauge":{"dataPoints":[{"timeUnixNano":"`,
+		`"asInt":"4"`,
+		`{"attributes":[{"key":"state","value":{"stringValue":"open"}}],"timeUnixNano":"`,
+		`"asInt":"2"`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("Expected %s in request body %s\n", expected, body)
+		}
+	}
+
+	var decoded exportRequest
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarshalProto(t *testing.T) {
+	value, count := "open", int64(2)
+	dp := dataPoint{
+		Attributes:   []keyValue{{Key: "state", Value: anyValue{StringValue: &value}}},
+		TimeUnixNano: 1,
+		AsInt:        &count,
+	}
+
+	expected := []byte{
+		0x19, 1, 0, 0, 0, 0, 0, 0, 0, // time_unix_nano
+		0x31, 2, 0, 0, 0, 0, 0, 0, 0, // as_int
+		0x3a, 15, // attributes
+		0x0a, 5, 's', 't', 'a', 't', 'e',
+		0x12, 6, 0x0a, 4, 'o', 'p', 'e', 'n',
+	}
+	if encoded := dp.marshalProto(); !bytes.Equal(encoded, expected) {
+		t.Fatalf("Expected % x but got % x\n", expected, encoded)
+	}
+}
+
+func TestConvertNumericTypes(t *testing.T) {
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": uint32(4)}))
+	m.Add(metrics.NewMetric("ci.duration", map[string]interface{}{"count": 1.5}))
+
+	req, _ := (&store{}).convert(m, m.Time)
+	gauges := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if dp := gauges[0].Gauge.DataPoints[0]; dp.AsInt == nil || *dp.AsInt != 4 {
+		t.Fatalf("Expected integer value 4 but got %+v\n", dp)
+	}
+	if dp := gauges[1].Gauge.DataPoints[0]; dp.AsDouble == nil || *dp.AsDouble != 1.5 {
+		t.Fatalf("Expected double value 1.5 but got %+v\n", dp)
+	}
+}
+
+func TestExportEvents(t *testing.T) {
+	var body []byte
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	s, err := New(&config.OTLPConfig{Endpoint: ts.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed export is not accounted for in the totals.
+	for _, code := range []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK} {
+		status = code
+		m := metrics.New(repository.Named("docker.docker"))
+		m.Events = true
+		m.Add(metrics.NewMetric("issues.events.closed", map[string]interface{}{"count": 1}))
+		m.Add(metrics.NewMetric("issues.close_delay", map[string]interface{}{"value": 24}))
+		if err := s.Send(m); (err != nil) != (code != http.StatusOK) {
+			t.Fatalf("Unexpected export error %v for status %d\n", err, code)
+		}
+	}
+
+	for _, expected := range []string{
+		`"name":"issues.events.closed","sum":{"dataPoints":[{"startTimeUnixNano":"`,
+		`"asInt":"2"}],"aggregationTemporality":2,"isMonotonic":true}`,
+		`"name":"issues.close_delay","gauge":{"dataPoints":[{"timeUnixNano":"`,
+		`"asInt":"24"`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("Expected %s in request body %s\n", expected, body)
+		}
+	}
+}
+
+func TestMarshalSumProto(t *testing.T) {
+	m := metric{Name: "a", Sum: &sum{AggregationTemporality: temporalityCumulative, IsMonotonic: true}}
+
+	expected := []byte{
+		0x0a, 1, 'a', // name
+		0x3a, 4, // sum
+		0x10, 2, // aggregation_temporality
+		0x18, 1, // is_monotonic
+	}
+	if encoded := m.marshalProto(); !bytes.Equal(encoded, expected) {
+		t.Fatalf("Expected % x but got % x\n", expected, encoded)
+	}
+}
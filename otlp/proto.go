@@ -0,0 +1,74 @@
+package otlp
+
+import (
+	"encoding/binary"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protoBuffer is a minimal protocol buffers encoder, which is all it takes to
+// serialize the few OTLP messages we send.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) bytes() []byte {
+	return b.buf
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	b.buf = append(b.buf, tmp[:n]...)
+}
+
+func (b *protoBuffer) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// message writes an embedded message field, which is always present.
+func (b *protoBuffer) message(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.buf = append(b.buf, data...)
+}
+
+// string writes a string field, omitted when empty as for proto3 defaults.
+func (b *protoBuffer) string(field int, s string) {
+	if s != "" {
+		b.forceString(field, s)
+	}
+}
+
+// forceString writes a string field even if empty, as required for the
+// members of a oneof.
+func (b *protoBuffer) forceString(field int, s string) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *protoBuffer) uint(field int, v uint64) {
+	b.key(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	b.key(field, wireVarint)
+	if v {
+		b.varint(1)
+	} else {
+		b.varint(0)
+	}
+}
+
+func (b *protoBuffer) fixed64(field int, v uint64) {
+	b.key(field, wireFixed64)
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	b.buf = append(b.buf, tmp[:]...)
+}
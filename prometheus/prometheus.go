@@ -0,0 +1,245 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	defaultListen = ":8080"
+	namePrefix    = "octostats_"
+	counterSuffix = "_total"
+)
+
+// New starts serving the metrics sent to the store in the Prometheus text
+// format on the `/metrics` path of the configured address.
+func New(c *config.PrometheusConfig) (*store, error) {
+	listen := c.Listen
+	if listen == "" {
+		listen = defaultListen
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStore()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Logger.Errorf("Prometheus endpoint stopped: %v", err)
+		}
+	}()
+
+	log.Logger.WithField("address", l.Addr()).Info("Serving Prometheus metrics")
+	return s, nil
+}
+
+func newStore() *store {
+	return &store{
+		families: make(map[string]map[string][]sample),
+		counters: make(map[string]map[string]float64),
+	}
+}
+
+// store keeps the last value of the gauges of each repository. A gauge is
+// named from the metric path and labelled with the repository and the string
+// and boolean fields of the metric data. Its value is the `value` or `count`
+// field, or the number of items with the same labels when there is none.
+//
+// Event metrics describe what happened since the previous batch rather than
+// the state of the repository: their counts are accumulated into counters
+// instead, while their measurements set a gauge to the last value.
+type store struct {
+	families map[string]map[string][]sample
+	counters map[string]map[string]float64
+	m        sync.Mutex
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+func (s *store) Send(m *metrics.Metrics) error {
+	nwo := m.Origin.Nwo()
+	if m.Events {
+		s.record(nwo, m.Items)
+		return nil
+	}
+
+	// Sum items sharing the same gauge and labels.
+	values := make(map[string]map[string]float64)
+	for _, item := range m.Items {
+		name := metricName(item.Path)
+		if values[name] == nil {
+			values[name] = make(map[string]float64)
+		}
+		labels, value := formatItem(nwo, item)
+		values[name][labels] += value
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Gauges are replaced as a whole for the repository, so that label sets
+	// which are no longer reported disappear.
+	for name, byLabels := range values {
+		var samples []sample
+		for labels, value := range byLabels {
+			samples = append(samples, sample{labels: labels, value: value})
+		}
+		sort.Sort(byLabel(samples))
+
+		if s.families[name] == nil {
+			s.families[name] = make(map[string][]sample)
+		}
+		s.families[name][nwo] = samples
+	}
+	return nil
+}
+
+func (s *store) record(nwo string, items []metrics.Metric) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, item := range items {
+		labels, value := formatItem(nwo, item)
+		if item.IsMeasurement() {
+			s.set(metricName(item.Path), nwo, sample{labels: labels, value: value})
+			continue
+		}
+
+		name := metricName(item.Path) + counterSuffix
+		if s.counters[name] == nil {
+			s.counters[name] = make(map[string]float64)
+		}
+		s.counters[name][labels] += value
+	}
+}
+
+// set replaces the sample of a gauge with the same labels, or adds it.
+func (s *store) set(name, nwo string, smp sample) {
+	if s.families[name] == nil {
+		s.families[name] = make(map[string][]sample)
+	}
+	samples := s.families[name][nwo]
+	for i := range samples {
+		if samples[i].labels == smp.labels {
+			samples[i] = smp
+			return
+		}
+	}
+	samples = append(samples, smp)
+	sort.Sort(byLabel(samples))
+	s.families[name][nwo] = samples
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(s.format())
+}
+
+func (s *store) format() []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var names []string
+	for name := range s.families {
+		names = append(names, name)
+	}
+	for name := range s.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		if counter, ok := s.counters[name]; ok {
+			fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+			var samples []sample
+			for labels, value := range counter {
+				samples = append(samples, sample{labels: labels, value: value})
+			}
+			sort.Sort(byLabel(samples))
+			for _, smp := range samples {
+				fmt.Fprintf(&buf, "%s{%s} %v\n", name, smp.labels, smp.value)
+			}
+			continue
+		}
+
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+
+		var repos []string
+		for nwo := range s.families[name] {
+			repos = append(repos, nwo)
+		}
+		sort.Strings(repos)
+
+		for _, nwo := range repos {
+			for _, smp := range s.families[name][nwo] {
+				fmt.Fprintf(&buf, "%s{%s} %v\n", name, smp.labels, smp.value)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// formatItem returns the formatted labels of a metric item and its value.
+func formatItem(nwo string, item metrics.Metric) (string, float64) {
+	labels := map[string]string{"repository": nwo}
+	for k, v := range item.Data {
+		switch v := v.(type) {
+		case string:
+			labels[sanitize(k)] = v
+		case bool:
+			labels[sanitize(k)] = fmt.Sprint(v)
+		}
+	}
+
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, labelEscaper.Replace(labels[k])))
+	}
+	return strings.Join(pairs, ","), item.Value()
+}
+
+func metricName(path string) string {
+	return namePrefix + sanitize(path)
+}
+
+// sanitize replaces the characters which aren't valid in Prometheus metric
+// and label names with underscores.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type byLabel []sample
+
+func (s byLabel) Len() int           { return len(s) }
+func (s byLabel) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLabel) Less(i, j int) bool { return s[i].labels < s[j].labels }
@@ -0,0 +1,89 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestFormat(t *testing.T) {
+	s := newStore()
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1347}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1348}))
+	m.Add(metrics.NewMetric("labels.data", map[string]interface{}{"name": `"quoted"`}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sending again replaces the previous gauges of the repository.
+	m = metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 3}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		`# TYPE octostats_issues_data gauge`,
+		`octostats_issues_data{repository="docker.docker",state="open"} 2`,
+		`# TYPE octostats_issues_open gauge`,
+		`octostats_issues_open{repository="docker.docker"} 3`,
+		`# TYPE octostats_labels_data gauge`,
+		`octostats_labels_data{name="\"quoted\"",repository="docker.docker"} 1`,
+		``,
+	}, "\n")
+	if output := string(s.format()); output != expected {
+		t.Fatalf("Expected output:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatEvents(t *testing.T) {
+	s := newStore()
+
+	for i := 0; i < 2; i++ {
+		m := metrics.New(repository.Named("docker.docker"))
+		m.Events = true
+		m.Add(metrics.NewMetric("issues.events.opened", map[string]interface{}{"count": 1}))
+		m.Add(metrics.NewMetric("issues.close_delay", map[string]interface{}{"value": 10 + i}))
+		if err := s.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := strings.Join([]string{
+		`# TYPE octostats_issues_close_delay gauge`,
+		`octostats_issues_close_delay{repository="docker.docker"} 11`,
+		`# TYPE octostats_issues_events_opened_total counter`,
+		`octostats_issues_events_opened_total{repository="docker.docker"} 2`,
+		``,
+	}, "\n")
+	if output := string(s.format()); output != expected {
+		t.Fatalf("Expected output:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatIntegerTypes(t *testing.T) {
+	s := newStore()
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": uint32(4)}))
+	m.Add(metrics.NewMetric("pull_requests.open", map[string]interface{}{"count": float32(2)}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		`# TYPE octostats_issues_open gauge`,
+		`octostats_issues_open{repository="docker.docker"} 4`,
+		`# TYPE octostats_pull_requests_open gauge`,
+		`octostats_pull_requests_open{repository="docker.docker"} 2`,
+		``,
+	}, "\n")
+	if output := string(s.format()); output != expected {
+		t.Fatalf("Expected output:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
@@ -1,9 +1,50 @@
 package repository
 
-import "github.com/octokit/go-octokit/octokit"
+import (
+	"fmt"
+
+	"github.com/octokit/go-octokit/octokit"
+)
 
 type Repository interface {
 	Nwo() string
 	Issues(string, string) ([]octokit.Issue, error)
 	PullRequests(string, string) ([]octokit.PullRequest, error)
 }
+
+// PartialError is returned along with results which are known to be
+// incomplete or stale, for example because of the GitHub rate limit.
+type PartialError struct {
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("partial results: %v", e.Err)
+}
+
+// IsPartial returns whether the error reports partial results, in which case
+// the results are still usable.
+func IsPartial(err error) bool {
+	_, ok := err.(*PartialError)
+	return ok
+}
+
+type named string
+
+// Named returns a repository which only provides its name, for metrics about
+// a repository which isn't tracked or no longer at hand.
+func Named(nwo string) Repository {
+	return named(nwo)
+}
+
+func (n named) Nwo() string {
+	return string(n)
+}
+
+func (n named) Issues(string, string) ([]octokit.Issue, error) {
+	return nil, fmt.Errorf("issues of %s are not available", string(n))
+}
+
+func (n named) PullRequests(string, string) ([]octokit.PullRequest, error) {
+	return nil, fmt.Errorf("pull requests of %s are not available", string(n))
+}
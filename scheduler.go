@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/repository"
+)
+
+// scheduler runs the periodic collection of each tracked repository in its
+// own goroutine, at the repository's own update frequency.
+type scheduler struct {
+	tasks   map[string]chan struct{}
+	sources map[string]repository.Repository
+	stopped bool
+	quit    chan struct{}
+	running sync.WaitGroup
+	m       sync.Mutex
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		tasks:   make(map[string]chan struct{}),
+		sources: make(map[string]repository.Repository),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Add starts collecting the given repository every frequency, and returns
+// false if the repository was already scheduled or the scheduler is stopped.
+func (s *scheduler) Add(source repository.Repository, frequency time.Duration) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key := scheduleKey(source.Nwo())
+	if _, ok := s.tasks[key]; ok || s.stopped {
+		return false
+	}
+
+	stop := make(chan struct{})
+	s.tasks[key] = stop
+	s.sources[key] = source
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		runSchedule(func() { onTimerTick(source) }, frequency, stop)
+	}()
+
+	log.Logger.WithField("repository", source.Nwo()).WithField("frequency", frequency).Info("Tracking repository")
+	return true
+}
+
+// Every runs fn every frequency until the scheduler is stopped, for what is
+// collected once for all repositories. It returns false if the scheduler is
+// already stopped.
+func (s *scheduler) Every(frequency time.Duration, fn func()) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		runSchedule(fn, frequency, s.quit)
+	}()
+	return true
+}
+
+// Remove stops collecting the repository identified by nwo.
+func (s *scheduler) Remove(nwo string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key := scheduleKey(nwo)
+	if stop, ok := s.tasks[key]; ok {
+		close(stop)
+		delete(s.tasks, key)
+		delete(s.sources, key)
+		log.Logger.WithField("repository", nwo).Info("Untracking repository")
+	}
+}
+
+// Stop terminates all scheduled collections, and waits for those which are
+// running to complete.
+func (s *scheduler) Stop() {
+	s.m.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.quit)
+	}
+	for key, stop := range s.tasks {
+		close(stop)
+		delete(s.tasks, key)
+		delete(s.sources, key)
+	}
+	s.m.Unlock()
+
+	s.running.Wait()
+}
+
+// Lookup returns the scheduled repository identified by nwo, regardless of
+// case as GitHub names are, or nil if there is none.
+func (s *scheduler) Lookup(nwo string) repository.Repository {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.sources[scheduleKey(nwo)]
+}
+
+// scheduleKey returns the key a repository is scheduled under: GitHub names
+// are case insensitive, so the same repository may be configured and
+// discovered with a different case.
+func scheduleKey(nwo string) string {
+	return strings.ToLower(nwo)
+}
+
+func runSchedule(tick func(), frequency time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(frequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tick()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestSchedulerCase(t *testing.T) {
+	s := newScheduler()
+	defer s.Stop()
+
+	if !s.Add(repository.Named("Docker.Docker"), time.Hour) {
+		t.Fatal("Expected repository to be scheduled")
+	}
+	if s.Add(repository.Named("docker.docker"), time.Hour) {
+		t.Fatal("Expected repository not to be scheduled twice regardless of case")
+	}
+	if r := s.Lookup("DOCKER.docker"); r == nil || r.Nwo() != "Docker.Docker" {
+		t.Fatalf("Expected lookup regardless of case but got %v\n", r)
+	}
+
+	s.Remove("docker.docker")
+	if r := s.Lookup("Docker.Docker"); r != nil {
+		t.Fatalf("Expected repository to be removed but got %v\n", r)
+	}
+}
+
+func TestSchedulerStopped(t *testing.T) {
+	s := newScheduler()
+	s.Add(repository.Named("docker.docker"), time.Hour)
+	s.Stop()
+
+	if s.Lookup("docker.docker") != nil {
+		t.Fatal("Expected no repository once stopped")
+	}
+	if s.Add(repository.Named("docker.swarm"), time.Hour) {
+		t.Fatal("Expected no repository to be scheduled once stopped")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/icecrime/octostats/amqp"
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/kafka"
+	"github.com/icecrime/octostats/nsq"
+)
+
+const (
+	sourceNSQ   = "nsq"
+	sourceKafka = "kafka"
+	sourceAMQP  = "amqp"
+)
+
+// EventSource is a queue GitHub events are consumed from, and passed to an
+// EventHandler.
+type EventSource interface {
+	// Stop stops consuming events, once those being handled are.
+	Stop()
+}
+
+// eventSourceType returns the type of the event source set in the `events`
+// block, or of the only one configured. It is empty when there is none.
+func eventSourceType(c *config.Config) (string, error) {
+	configured := map[string]bool{
+		sourceNSQ:   c.NSQConfig != nil,
+		sourceKafka: c.KafkaConfig != nil,
+		sourceAMQP:  c.AMQPConfig != nil,
+	}
+
+	if t := c.EventsConfig.Source; t != "" {
+		if ok, known := configured[t]; !known {
+			return "", fmt.Errorf("invalid event source '%s'", t)
+		} else if !ok {
+			return "", fmt.Errorf("missing %s configuration for the event source", t)
+		}
+		return t, nil
+	}
+
+	var result string
+	for _, t := range []string{sourceNSQ, sourceKafka, sourceAMQP} {
+		if !configured[t] {
+			continue
+		}
+		if result != "" {
+			return "", fmt.Errorf("several event sources configured: set events.source to choose one")
+		}
+		result = t
+	}
+	return result, nil
+}
+
+// newEventSource starts consuming events from the configured source, if any.
+func newEventSource(c *config.Config, handler *EventHandler) (EventSource, error) {
+	t, err := eventSourceType(c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case sourceNSQ:
+		queue, err := nsq.New(c.NSQConfig, handler)
+		if err != nil {
+			return nil, err
+		}
+		return queue, nil
+	case sourceKafka:
+		consumer, err := kafka.New(c.KafkaConfig, handler)
+		if err != nil {
+			return nil, err
+		}
+		return consumer, nil
+	case sourceAMQP:
+		consumer, err := amqp.New(c.AMQPConfig, handler)
+		if err != nil {
+			return nil, err
+		}
+		return consumer, nil
+	}
+	return nil, nil
+}
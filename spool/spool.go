@@ -0,0 +1,375 @@
+package spool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/journal"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+const (
+	defaultMaxSize      = 64 * 1024 * 1024
+	defaultMaxAge       = 24 * time.Hour
+	defaultRetryBackoff = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+)
+
+// Backend is the store the spool delivers metrics to.
+type Backend interface {
+	Send(*metrics.Metrics) error
+}
+
+// batch is the serialized form of a metrics batch.
+type batch struct {
+	Nwo     string           `json:"nwo"`
+	Time    time.Time        `json:"time"`
+	Partial bool             `json:"partial"`
+	Events  bool             `json:"events,omitempty"`
+	Items   []metrics.Metric `json:"items"`
+}
+
+// record is a single entry of the spool log: either a batch to deliver, or
+// an acknowledgement that all batches up to Seq were delivered or dropped.
+type record struct {
+	Seq   uint64          `json:"seq"`
+	Batch json.RawMessage `json:"batch,omitempty"`
+	Ack   bool            `json:"ack,omitempty"`
+}
+
+type entry struct {
+	seq   uint64
+	batch json.RawMessage
+	time  time.Time
+}
+
+// Store wraps a backend store: batches which fail to be sent are appended to
+// a write-ahead journal, and replayed in order with an exponential backoff
+// until the backend accepts them. Batches are dropped, oldest first, when the
+// spool exceeds its size or age limits, and so are the ones the backend
+// rejects permanently or which ran out of attempts.
+type Store struct {
+	backend Backend
+	path    string
+	journal *journal.Log
+
+	pending []entry
+	size    int64
+	nextSeq uint64
+
+	maxSize      int64
+	maxAge       time.Duration
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	m    sync.Mutex
+}
+
+// New opens, or creates, the spool for a backend store. Batches left in the
+// spool by a previous run are replayed first.
+func New(c *config.SpoolConfig, backend Backend) (*Store, error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("missing path for spool")
+	}
+
+	s := &Store{
+		backend:      backend,
+		path:         c.Path,
+		maxSize:      c.MaxSize,
+		maxAge:       defaultMaxAge,
+		retryBackoff: defaultRetryBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		maxAttempts:  c.MaxAttempts,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{c.MaxAge, &s.maxAge},
+		{c.RetryBackoff, &s.retryBackoff},
+		{c.MaxBackoff, &s.maxBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, err
+		}
+		*d.target = duration
+	}
+
+	l, err := journal.Open(s.path, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = l
+	if err := s.compact(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if len(s.pending) > 0 {
+		log.Logger.WithField("spool", s.path).Infof("Replaying %d spooled batches", len(s.pending))
+		s.wake <- struct{}{}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Send delivers the metrics to the backend, or spools them if it fails for a
+// reason which isn't permanent. While the spool isn't empty, new batches are
+// queued behind the spooled ones to preserve their order.
+func (s *Store) Send(m *metrics.Metrics) error {
+	s.m.Lock()
+	empty := len(s.pending) == 0
+	s.m.Unlock()
+
+	if empty {
+		err := s.backend.Send(m)
+		if err == nil || delivery.IsPermanent(err) {
+			return err
+		}
+		log.Logger.WithField("spool", s.path).Warnf("Spooling metrics of %s: %v", m.Origin.Nwo(), err)
+	}
+
+	if err := s.append(m); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops the replay of the spool and closes its file.
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.journal.Close()
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+
+	backoff := s.retryBackoff
+	var seq uint64
+	var attempts int
+	for {
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+
+		for {
+			e, ok := s.head()
+			if !ok {
+				backoff = s.retryBackoff
+				break
+			}
+			if e.seq != seq {
+				seq, attempts = e.seq, 0
+			}
+			attempts++
+
+			err := s.backend.Send(decode(e.batch))
+			if err != nil && (delivery.IsPermanent(err) || attempts == s.maxAttempts) {
+				log.Logger.WithField("spool", s.path).Errorf("Dropping spooled batch after %d attempts: %v", attempts, err)
+			} else if err != nil {
+				log.Logger.WithField("spool", s.path).Warnf("Spool replay failed, retrying in %v: %v", backoff, err)
+				select {
+				case <-time.After(backoff):
+				case <-s.stop:
+					return
+				}
+				if backoff *= 2; backoff > s.maxBackoff {
+					backoff = s.maxBackoff
+				}
+				continue
+			}
+
+			backoff = s.retryBackoff
+			s.m.Lock()
+			s.dropHead(1)
+			s.m.Unlock()
+		}
+	}
+}
+
+// head returns the oldest batch of the spool, dropping the expired ones.
+func (s *Store) head() (entry, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	expired := 0
+	for expired < len(s.pending) && time.Since(s.pending[expired].time) > s.maxAge {
+		expired++
+	}
+	if expired > 0 {
+		log.Logger.WithField("spool", s.path).Warnf("Dropping %d expired batches", expired)
+		s.dropHead(expired)
+	}
+
+	if len(s.pending) == 0 {
+		return entry{}, false
+	}
+	return s.pending[0], true
+}
+
+func (s *Store) append(m *metrics.Metrics) error {
+	b, err := json.Marshal(batch{
+		Nwo:     m.Origin.Nwo(),
+		Time:    m.Time,
+		Partial: m.Partial,
+		Events:  m.Events,
+		Items:   m.Items,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	e := entry{seq: s.nextSeq, batch: b, time: m.Time}
+	if err := s.write(record{Seq: e.seq, Batch: b}); err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.pending = append(s.pending, e)
+	s.size += int64(len(b))
+
+	// The latest batch is always kept.
+	dropped, size := 0, s.size
+	for size > s.maxSize && dropped < len(s.pending)-1 {
+		size -= int64(len(s.pending[dropped].batch))
+		dropped++
+	}
+	if dropped > 0 {
+		log.Logger.WithField("spool", s.path).Warnf("Spool full: dropping %d oldest batches", dropped)
+		s.dropHead(dropped)
+	}
+	return nil
+}
+
+func (s *Store) droppedSize(n int) int64 {
+	var size int64
+	for _, e := range s.pending[:n] {
+		size += int64(len(e.batch))
+	}
+	return size
+}
+
+// dropHead removes the n oldest batches from the spool, and records it in the
+// log. The caller must hold the lock.
+func (s *Store) dropHead(n int) {
+	last := s.pending[n-1].seq
+	s.size -= s.droppedSize(n)
+	s.pending = s.pending[n:]
+
+	if err := s.write(record{Seq: last, Ack: true}); err != nil {
+		log.Logger.WithField("spool", s.path).Error(err)
+	}
+	if s.journal.Records() > 2*len(s.pending) {
+		if err := s.compact(); err != nil {
+			log.Logger.WithField("spool", s.path).Error(err)
+		}
+	}
+}
+
+func (s *Store) write(r record) error {
+	if err := s.journal.Append(r); err != nil {
+		return err
+	}
+	return s.journal.Sync()
+}
+
+// replay restores a record of the journal left by a previous run.
+func (s *Store) replay(data []byte) error {
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Seq >= s.nextSeq {
+		s.nextSeq = r.Seq + 1
+	}
+
+	if r.Ack {
+		for len(s.pending) > 0 && s.pending[0].seq <= r.Seq {
+			s.size -= int64(len(s.pending[0].batch))
+			s.pending = s.pending[1:]
+		}
+		return nil
+	}
+
+	var b batch
+	if err := json.Unmarshal(r.Batch, &b); err != nil {
+		return err
+	}
+	s.pending = append(s.pending, entry{seq: r.Seq, batch: r.Batch, time: b.Time})
+	s.size += int64(len(r.Batch))
+	return nil
+}
+
+// compact rewrites the journal with the pending batches only. The caller must
+// hold the lock, if any.
+func (s *Store) compact() error {
+	records := make([]interface{}, 0, len(s.pending))
+	for _, e := range s.pending {
+		records = append(records, record{Seq: e.seq, Batch: e.batch})
+	}
+	return s.journal.Compact(records)
+}
+
+// decode restores a spooled batch. JSON numbers are decoded as integers when
+// possible, as they were collected.
+func decode(data json.RawMessage) *metrics.Metrics {
+	var b batch
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	d.Decode(&b)
+
+	for _, item := range b.Items {
+		for k, v := range item.Data {
+			n, ok := v.(json.Number)
+			if !ok {
+				continue
+			}
+			if i, err := strconv.Atoi(n.String()); err == nil {
+				item.Data[k] = i
+			} else if f, err := n.Float64(); err == nil {
+				item.Data[k] = f
+			}
+		}
+	}
+
+	m := metrics.New(repository.Named(b.Nwo))
+	m.Items = b.Items
+	m.Time = b.Time
+	m.Partial = b.Partial
+	m.Events = b.Events
+	return m
+}
@@ -0,0 +1,195 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+type flakyBackend struct {
+	failing  bool
+	rejected map[int]bool
+	sent     []*metrics.Metrics
+	m        sync.Mutex
+}
+
+func (b *flakyBackend) setFailing(failing bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.failing = failing
+}
+
+func (b *flakyBackend) Send(m *metrics.Metrics) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.failing {
+		return errors.New("backend unavailable")
+	}
+	if b.rejected[m.Items[0].Data["count"].(int)] {
+		return delivery.Permanent(errors.New("invalid batch"))
+	}
+	b.sent = append(b.sent, m)
+	return nil
+}
+
+func (b *flakyBackend) count() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return len(b.sent)
+}
+
+func testBatch(n int) *metrics.Metrics {
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": n}))
+	return m
+}
+
+func (s *Store) spooled() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.pending)
+}
+
+func waitEmpty(t *testing.T, s *Store) {
+	for deadline := time.Now().Add(5 * time.Second); s.spooled() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected spool to be emptied but got %d spooled batches\n", s.spooled())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitSent(t *testing.T, b *flakyBackend, n int) {
+	for deadline := time.Now().Add(5 * time.Second); b.count() < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d batches to be sent but got %d\n", n, b.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyBackend{failing: true}
+	c := &config.SpoolConfig{Path: filepath.Join(dir, "spool"), RetryBackoff: "10ms"}
+	s, err := New(c, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Send(testBatch(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// Batches spooled by a previous run are replayed when reopened.
+	backend.setFailing(false)
+	if s, err = New(c, backend); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitSent(t, backend, 4)
+	for i, m := range backend.sent {
+		if count := m.Items[0].Data["count"]; count != i {
+			t.Fatalf("Expected batch %d to have count %d but got %v\n", i, i, count)
+		}
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyBackend{failing: true}
+	s, err := New(&config.SpoolConfig{Path: filepath.Join(dir, "spool"), MaxAge: "1h", RetryBackoff: "10ms"}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	old := testBatch(0)
+	old.Time = time.Now().Add(-2 * time.Hour)
+	s.Send(old)
+	s.Send(testBatch(1))
+	backend.setFailing(false)
+
+	waitSent(t, backend, 1)
+	time.Sleep(50 * time.Millisecond)
+	if n := backend.count(); n != 1 || backend.sent[0].Items[0].Data["count"] != 1 {
+		t.Fatalf("Expected only the recent batch to be sent but got %d batches\n", n)
+	}
+}
+
+func TestSpoolRejectedBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyBackend{failing: true, rejected: map[int]bool{0: true, 2: true}}
+	s, err := New(&config.SpoolConfig{Path: filepath.Join(dir, "spool"), RetryBackoff: "10ms"}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A rejected batch doesn't hold back the ones spooled behind it.
+	s.Send(testBatch(0))
+	s.Send(testBatch(1))
+	backend.setFailing(false)
+	waitSent(t, backend, 1)
+	waitEmpty(t, s)
+
+	// Rejected batches are not spooled.
+	if err := s.Send(testBatch(2)); !delivery.IsPermanent(err) {
+		t.Fatalf("Expected rejected batch to fail permanently but got %v\n", err)
+	}
+	if n := s.spooled(); n != 0 {
+		t.Fatalf("Expected rejected batch not to be spooled but got %d spooled batches\n", n)
+	}
+	if count := backend.sent[0].Items[0].Data["count"]; backend.count() != 1 || count != 1 {
+		t.Fatalf("Expected only the valid batch to be sent but got %d batches\n", backend.count())
+	}
+}
+
+func TestSpoolMaxAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyBackend{failing: true}
+	s, err := New(&config.SpoolConfig{Path: filepath.Join(dir, "spool"), RetryBackoff: "10ms", MaxAttempts: 2}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The batch is dropped although the backend never recovers.
+	s.Send(testBatch(0))
+	waitEmpty(t, s)
+}
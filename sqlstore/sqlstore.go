@@ -0,0 +1,225 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/log"
+	"github.com/icecrime/octostats/metrics"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect holds the differences between the supported databases.
+type dialect struct {
+	serial string
+	bind   func(n int) string
+
+	// exclusive is set for databases which lock the whole database for a
+	// write: writes are then serialized on a single connection, as batches
+	// are sent concurrently.
+	exclusive bool
+}
+
+var dialects = map[string]dialect{
+	"sqlite3": {
+		serial:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+		bind:      func(int) string { return "?" },
+		exclusive: true,
+	},
+	"postgres": {
+		serial: "SERIAL PRIMARY KEY",
+		bind:   func(n int) string { return "$" + strconv.Itoa(n) },
+	},
+}
+
+// migrations are the successive versions of the schema. Applied versions are
+// recorded in the schema_migrations table, and new ones are applied when the
+// store is opened: existing migrations must never be modified.
+var migrations = [][]string{
+	{
+		`CREATE TABLE snapshots (
+			id {serial},
+			repository TEXT NOT NULL,
+			collected_at TIMESTAMP NOT NULL,
+			partial BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX snapshots_repository ON snapshots (repository, collected_at)`,
+		`CREATE TABLE snapshot_metrics (
+			snapshot_id INTEGER NOT NULL REFERENCES snapshots (id),
+			path TEXT NOT NULL,
+			value BIGINT,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE issues (
+			snapshot_id INTEGER NOT NULL REFERENCES snapshots (id),
+			number INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE pull_requests (
+			snapshot_id INTEGER NOT NULL REFERENCES snapshots (id),
+			number INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			merged BOOLEAN NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		`ALTER TABLE snapshots ADD COLUMN events BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+}
+
+// New opens the configured database and brings its schema up to date.
+func New(c *config.SQLConfig) (*store, error) {
+	d, ok := dialects[c.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL driver '%s'", c.Driver)
+	}
+
+	db, err := sql.Open(c.Driver, c.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if d.exclusive {
+		db.SetMaxOpenConns(1)
+	}
+	s := &store{db: db, dialect: d}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// store writes each batch of metrics as a snapshot of the repository, with
+// its metrics and the issues and pull requests it holds. Batches of events
+// are snapshots too, with their `events` column set.
+type store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (s *store) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		log.Logger.WithField("version", version).Info("Migrating SQL schema")
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range migrations[version-1] {
+			if _, err := tx.Exec(strings.Replace(stmt, "{serial}", s.dialect.serial, -1)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d failed: %v", version, err)
+			}
+		}
+		if _, err := tx.Exec(s.query(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query replaces the `?` placeholders of a query with the ones of the
+// dialect.
+func (s *store) query(q string) string {
+	parts := strings.Split(q, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = s.dialect.bind(i) + parts[i]
+	}
+	return strings.Join(parts, "")
+}
+
+func (s *store) Send(m *metrics.Metrics) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := s.insert(tx, m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *store) insert(tx *sql.Tx, m *metrics.Metrics) error {
+	var snapshot int64
+	err := tx.QueryRow(
+		s.query(`INSERT INTO snapshots (repository, collected_at, partial, events) VALUES (?, ?, ?, ?) RETURNING id`),
+		m.Origin.Nwo(), m.Time.UTC(), m.Partial, m.Events,
+	).Scan(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range m.Items {
+		var err error
+		switch item.Path {
+		case "issues.data":
+			_, err = tx.Exec(
+				s.query(`INSERT INTO issues (snapshot_id, number, state, created_at) VALUES (?, ?, ?, ?)`),
+				snapshot, item.Data["id"], item.Data["state"], unixTime(item.Data["time"]),
+			)
+		case "pull_requests.data":
+			_, err = tx.Exec(
+				s.query(`INSERT INTO pull_requests (snapshot_id, number, state, merged, created_at) VALUES (?, ?, ?, ?, ?)`),
+				snapshot, item.Data["id"], item.Data["state"], item.Data["merged"] == true, unixTime(item.Data["time"]),
+			)
+		default:
+			err = s.insertMetric(tx, snapshot, item)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) insertMetric(tx *sql.Tx, snapshot int64, item metrics.Metric) error {
+	data, err := json.Marshal(item.Data)
+	if err != nil {
+		return err
+	}
+
+	// The value is left NULL for metrics which have none.
+	var value interface{}
+	if _, ok := metrics.Value(item.Data[metrics.CountField]); ok || item.IsMeasurement() {
+		value = int64(item.Value())
+	}
+	_, err = tx.Exec(
+		s.query(`INSERT INTO snapshot_metrics (snapshot_id, path, value, data) VALUES (?, ?, ?, ?)`),
+		snapshot, item.Path, value, string(data),
+	)
+	return err
+}
+
+func unixTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(v, 0).UTC()
+	case int:
+		return time.Unix(int64(v), 0).UTC()
+	case float64:
+		return time.Unix(int64(v), 0).UTC()
+	}
+	return time.Time{}
+}
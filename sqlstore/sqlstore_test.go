@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func TestSendSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.SQLConfig{Driver: "sqlite3", DSN: filepath.Join(dir, "octostats.db")}
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 1}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": int64(1303479228), "state": "open", "id": 1347}))
+	m.Add(metrics.NewMetric("pull_requests.data", map[string]interface{}{"time": int64(1303479228), "state": "closed", "merged": true, "id": 1348}))
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Close()
+
+	// Reopening the database doesn't migrate it again.
+	if s, err = New(c); err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	var repo string
+	var merged int
+	err = s.db.QueryRow(`SELECT s.repository, COUNT(*) FROM pull_requests p JOIN snapshots s ON s.id = p.snapshot_id WHERE p.merged`).Scan(&repo, &merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo != "docker.docker" || merged != 1 {
+		t.Fatalf("Expected 1 merged pull request for docker.docker but got %d for %s\n", merged, repo)
+	}
+
+	var value int
+	if err := s.db.QueryRow(`SELECT value FROM snapshot_metrics WHERE path = 'issues.open'`).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 1 {
+		t.Fatalf("Expected 1 open issue but got %d\n", value)
+	}
+}
+
+func TestSendEventsSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(&config.SQLConfig{Driver: "sqlite3", DSN: filepath.Join(dir, "octostats.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	// Batches are sent concurrently by the repository ticks and the events.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := metrics.New(repository.Named("docker.docker"))
+			m.Events = true
+			m.Add(metrics.NewMetric("issues.close_delay", map[string]interface{}{"value": 24}))
+			errs <- s.Send(m)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count, value int
+	err = s.db.QueryRow(`SELECT COUNT(*), MAX(value) FROM snapshot_metrics m JOIN snapshots s ON s.id = m.snapshot_id WHERE s.events`).Scan(&count, &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 20 || value != 24 {
+		t.Fatalf("Expected 20 event delays of 24 but got %d of %d\n", count, value)
+	}
+}
+
+func TestQueryPlaceholders(t *testing.T) {
+	s := &store{dialect: dialects["postgres"]}
+	if q := s.query(`INSERT INTO t (a, b) VALUES (?, ?)`); q != `INSERT INTO t (a, b) VALUES ($1, $2)` {
+		t.Fatalf("Unexpected query %s\n", q)
+	}
+}
@@ -0,0 +1,165 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/icecrime/octostats/config"
+	"github.com/icecrime/octostats/metrics"
+)
+
+const (
+	defaultPrefix = "octostats"
+
+	// maxPacketSize keeps datagrams within a typical network MTU.
+	maxPacketSize = 1432
+)
+
+// New returns a store sending gauges to a StatsD server. Without tag support,
+// the repository and tag values are part of the gauge names.
+func New(c *config.StatsDConfig) (*store, error) {
+	return newStore(c, false)
+}
+
+// NewDogStatsD returns a store sending gauges to a DogStatsD agent, with the
+// repository, state and label as tags.
+func NewDogStatsD(c *config.StatsDConfig) (*store, error) {
+	return newStore(c, true)
+}
+
+func newStore(c *config.StatsDConfig, tagged bool) (*store, error) {
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &store{conn: conn, prefix: prefix, tagged: tagged}, nil
+}
+
+type store struct {
+	conn   net.Conn
+	prefix string
+	tagged bool
+}
+
+func (s *store) Send(m *metrics.Metrics) error {
+	for _, packet := range packets(s.format(m)) {
+		if _, err := s.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type gauge struct {
+	path  string
+	tags  []string
+	value float64
+	kind  string
+}
+
+// format returns one gauge line per metric path and set of tags. The gauge
+// value is the `value` or `count` field of the metric, or the number of items
+// sharing the same tags for per-item metrics. The counts of event metrics are
+// sent as counters instead, which the server accumulates.
+func (s *store) format(m *metrics.Metrics) [][]byte {
+	var gauges []*gauge
+	index := make(map[string]*gauge)
+	for _, item := range m.Items {
+		g := newGauge(m.Origin.Nwo(), item)
+		if m.Events && !item.IsMeasurement() {
+			g.kind = "c"
+		}
+		key := g.path + "|" + strings.Join(g.tags, ",")
+		if existing, ok := index[key]; ok {
+			if item.IsMeasurement() {
+				existing.value = g.value
+			} else {
+				existing.value += g.value
+			}
+			continue
+		}
+		index[key] = g
+		gauges = append(gauges, g)
+	}
+
+	var lines [][]byte
+	for _, g := range gauges {
+		var line string
+		if s.tagged {
+			line = fmt.Sprintf("%s.%s:%v|%s|#%s", s.prefix, g.path, g.value, g.kind, strings.Join(g.tags, ","))
+		} else {
+			name := []string{s.prefix, sanitize(m.Origin.Nwo()), g.path}
+			for _, t := range g.tags[1:] {
+				name = append(name, sanitize(t[strings.Index(t, ":")+1:]))
+			}
+			line = fmt.Sprintf("%s:%v|%s", strings.Join(name, "."), g.value, g.kind)
+		}
+		lines = append(lines, []byte(line))
+	}
+	return lines
+}
+
+func newGauge(nwo string, item metrics.Metric) *gauge {
+	g := &gauge{path: sanitize(item.Path), value: item.Value(), kind: "g"}
+	var tags []string
+	for k, v := range item.Data {
+		switch v := v.(type) {
+		case string:
+			tags = append(tags, tagName(k)+":"+sanitize(v))
+		case bool:
+			tags = append(tags, tagName(k)+":"+fmt.Sprint(v))
+		}
+	}
+	sort.Strings(tags)
+
+	// The repository always comes first.
+	g.tags = append([]string{"repository:" + sanitize(nwo)}, tags...)
+	return g
+}
+
+// tagName renames the label name field to a `label` tag.
+func tagName(field string) string {
+	if field == "name" {
+		return "label"
+	}
+	return field
+}
+
+// packets groups lines into newline separated datagrams.
+func packets(lines [][]byte) [][]byte {
+	var result [][]byte
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+len(l)+1 > maxPacketSize {
+			result = append(result, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.Write(l)
+	}
+	if buf.Len() > 0 {
+		result = append(result, buf.Bytes())
+	}
+	return result
+}
+
+// sanitize replaces the characters which have a meaning in the protocol.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
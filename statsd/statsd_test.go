@@ -0,0 +1,71 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+func testMetrics() *metrics.Metrics {
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": 4}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1347}))
+	m.Add(metrics.NewMetric("issues.data", map[string]interface{}{"time": 1303479228, "state": "open", "id": 1348}))
+	m.Add(metrics.NewMetric("labels.data", map[string]interface{}{"name": "bug"}))
+	return m
+}
+
+func TestFormat(t *testing.T) {
+	s := &store{prefix: "octostats"}
+	expected := strings.Join([]string{
+		"octostats.docker.docker.issues.open:4|g",
+		"octostats.docker.docker.issues.data.open:2|g",
+		"octostats.docker.docker.labels.data.bug:1|g",
+	}, "\n")
+	if output := string(packets(s.format(testMetrics()))[0]); output != expected {
+		t.Fatalf("Expected packet:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatTagged(t *testing.T) {
+	s := &store{prefix: "octostats", tagged: true}
+	expected := strings.Join([]string{
+		"octostats.issues.open:4|g|#repository:docker.docker",
+		"octostats.issues.data:2|g|#repository:docker.docker,state:open",
+		"octostats.labels.data:1|g|#repository:docker.docker,label:bug",
+	}, "\n")
+	if output := string(packets(s.format(testMetrics()))[0]); output != expected {
+		t.Fatalf("Expected packet:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatIntegerTypes(t *testing.T) {
+	s := &store{prefix: "octostats"}
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Add(metrics.NewMetric("issues.open", map[string]interface{}{"count": int64(4)}))
+	m.Add(metrics.NewMetric("pull_requests.open", map[string]interface{}{"count": uint32(2)}))
+	expected := strings.Join([]string{
+		"octostats.docker.docker.issues.open:4|g",
+		"octostats.docker.docker.pull_requests.open:2|g",
+	}, "\n")
+	if output := string(packets(s.format(m))[0]); output != expected {
+		t.Fatalf("Expected packet:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
+
+func TestFormatEvents(t *testing.T) {
+	s := &store{prefix: "octostats", tagged: true}
+	m := metrics.New(repository.Named("docker.docker"))
+	m.Events = true
+	m.Add(metrics.NewMetric("issues.events.closed", map[string]interface{}{"count": 1}))
+	m.Add(metrics.NewMetric("issues.close_delay", map[string]interface{}{"value": 24}))
+	expected := strings.Join([]string{
+		"octostats.issues.events.closed:1|c|#repository:docker.docker",
+		"octostats.issues.close_delay:24|g|#repository:docker.docker",
+	}, "\n")
+	if output := string(packets(s.format(m))[0]); output != expected {
+		t.Fatalf("Expected packet:\n%s\nbut got:\n%s\n", expected, output)
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/icecrime/octostats/log"
 	"github.com/icecrime/octostats/metrics"
 )
@@ -14,8 +20,83 @@ type debugStore struct {
 
 func (*debugStore) Send(m *metrics.Metrics) error {
 	log.Logger.WithField("origin", m.Origin.Nwo()).Info("Sending metrics")
-	for k, v := range m.Items {
-		log.Logger.Info("  %s = %d", k, v)
+	for _, v := range m.Items {
+		log.Logger.Infof("  %s = %v", v.Path, v.Data)
+	}
+	return nil
+}
+
+// closeStore releases the resources held by the store, such as the spool
+// file, if it has any.
+func closeStore(s Store) {
+	if c, ok := s.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Logger.Error(err)
+		}
+	}
+}
+
+type namedStore struct {
+	name  string
+	store Store
+}
+
+// StoreErrors reports the failure of some outputs, keyed by name.
+type StoreErrors map[string]error
+
+func (e StoreErrors) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e[name]))
+	}
+	return fmt.Sprintf("%d outputs failed (%s)", len(e), strings.Join(msgs, "; "))
+}
+
+// fanoutStore sends metrics to several outputs in parallel. A failing output
+// doesn't prevent the delivery to the others: the failures are returned as
+// StoreErrors once all outputs are done. Send therefore blocks for as long as
+// the slowest output, which network outputs bound with their own timeouts.
+type fanoutStore struct {
+	outputs []namedStore
+}
+
+func newFanoutStore(outputs []namedStore) *fanoutStore {
+	return &fanoutStore{outputs: outputs}
+}
+
+func (f *fanoutStore) Send(m *metrics.Metrics) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(StoreErrors)
+	for _, o := range f.outputs {
+		wg.Add(1)
+		go func(o namedStore) {
+			defer wg.Done()
+			if err := o.store.Send(m); err != nil {
+				mu.Lock()
+				errs[o.name] = err
+				mu.Unlock()
+			}
+		}(o)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close closes all outputs.
+func (f *fanoutStore) Close() error {
+	for _, o := range f.outputs {
+		closeStore(o.store)
 	}
 	return nil
 }
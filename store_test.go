@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/icecrime/octostats/metrics"
+	"github.com/icecrime/octostats/repository"
+)
+
+type failingStore struct {
+	err error
+}
+
+func (s failingStore) Send(*metrics.Metrics) error {
+	return s.err
+}
+
+func TestFanoutStore(t *testing.T) {
+	console, influx := &recordingStore{}, &recordingStore{}
+	f := newFanoutStore([]namedStore{
+		{name: "console", store: console},
+		{name: "influxdb", store: influx},
+	})
+
+	m := metrics.New(repository.Named("docker.docker"))
+	if err := f.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	if len(console.sent) != 1 || len(influx.sent) != 1 {
+		t.Fatal("Expected metrics to be sent to both outputs")
+	}
+}
+
+func TestFanoutStorePartialFailure(t *testing.T) {
+	recorder := &recordingStore{}
+	f := newFanoutStore([]namedStore{
+		{name: "console", store: recorder},
+		{name: "influxdb", store: failingStore{errors.New("connection refused")}},
+	})
+
+	m := metrics.New(repository.Named("docker.docker"))
+	err := f.Send(m)
+	errs, ok := err.(StoreErrors)
+	if !ok || len(errs) != 1 || errs["influxdb"] == nil {
+		t.Fatalf("Expected the influxdb output to be reported as failed but got %v\n", err)
+	}
+	if expected := "1 outputs failed (influxdb: connection refused)"; err.Error() != expected {
+		t.Fatalf("Expected error %q but got %q\n", expected, err.Error())
+	}
+	if len(recorder.sent) != 1 || recorder.sent[0] != m {
+		t.Fatal("Expected metrics to be delivered to the succeeding output")
+	}
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/icecrime/octostats/delivery"
+	"github.com/icecrime/octostats/log"
+)
+
+const (
+	defaultListen = ":8081"
+	defaultPath   = "/webhook"
+
+	// GitHub caps payloads to 25MB.
+	maxPayloadSize = 25 * 1024 * 1024
+
+	signatureHeader = "X-Hub-Signature-256"
+	eventHeader     = "X-GitHub-Event"
+	deliveryHeader  = "X-GitHub-Delivery"
+
+	// GitHub gives up on deliveries after 10 seconds, but handling them may
+	// wait on slower outputs: these bound how long a connection is held.
+	readTimeout     = 30 * time.Second
+	writeTimeout    = time.Minute
+	idleTimeout     = 2 * time.Minute
+	shutdownTimeout = 30 * time.Second
+)
+
+type Config struct {
+	Listen     string `json:"listen"`
+	Path       string `json:"path"`
+	Secret     string `json:"secret"`
+	SecretFile string `json:"secretfile"`
+}
+
+// Server receives GitHub webhook deliveries over HTTP, and passes the ones
+// which are signed with the shared secret to its handler.
+type Server struct {
+	secret   []byte
+	handler  delivery.Handler
+	listener net.Listener
+	server   *http.Server
+}
+
+// New starts serving webhook deliveries on the configured address and path.
+func New(c *Config, handler delivery.Handler) (*Server, error) {
+	secret := c.Secret
+	if secret == "" && c.SecretFile != "" {
+		b, err := ioutil.ReadFile(c.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = strings.TrimSpace(string(b))
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("missing webhook secret")
+	}
+
+	listen, path := c.Listen, c.Path
+	if listen == "" {
+		listen = defaultListen
+	}
+	if path == "" {
+		path = defaultPath
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{secret: []byte(secret), handler: handler, listener: l}
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	go s.server.Serve(l)
+
+	log.Logger.WithField("address", l.Addr()).Infof("Receiving webhooks on %s", path)
+	return s, nil
+}
+
+// Close stops receiving webhook deliveries, and waits for the ones being
+// handled to complete, for at most shutdownTimeout.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "cannot read payload", http.StatusBadRequest)
+		return
+	}
+
+	logger := log.Logger.WithField("delivery", r.Header.Get(deliveryHeader))
+	if !s.validSignature(r.Header.Get(signatureHeader), payload) {
+		logger.Warn("Webhook delivery rejected: bad signature")
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := r.Header.Get(eventHeader)
+	if event == "" {
+		http.Error(w, "missing event type", http.StatusBadRequest)
+		return
+	}
+	if event == "ping" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	logger.WithField("event", event).Debug("Webhook event received")
+	if err := s.handler.HandleEvent(event, payload); err != nil {
+		logger.Error(err)
+		status := http.StatusInternalServerError
+		if delivery.IsPermanent(err) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "cannot handle event", status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validSignature checks the `sha256=<hex HMAC>` signature of the payload.
+func (s *Server) validSignature(header string, payload []byte) bool {
+	if !strings.HasPrefix(header, "sha256=") {
+		return false
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	events []string
+}
+
+func (h *recordingHandler) HandleEvent(event string, payload []byte) error {
+	h.events = append(h.events, event)
+	return nil
+}
+
+func sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestServeHTTP(t *testing.T) {
+	handler := &recordingHandler{}
+	s := &Server{secret: []byte("secret"), handler: handler}
+	payload := []byte(`{"action":"opened"}`)
+
+	for _, tc := range []struct {
+		signature string
+		status    int
+	}{
+		{sign([]byte("secret"), payload), http.StatusNoContent},
+		{sign([]byte("other"), payload), http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+		req.Header.Set(eventHeader, "issues")
+		req.Header.Set(signatureHeader, tc.signature)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Fatalf("Expected status %d for signature %q but got %d\n", tc.status, tc.signature, w.Code)
+		}
+	}
+
+	if len(handler.events) != 1 || handler.events[0] != "issues" {
+		t.Fatalf("Expected a single issues event to be handled but got %v\n", handler.events)
+	}
+}
+
+type blockingHandler struct {
+	started, release chan struct{}
+}
+
+func (h *blockingHandler) HandleEvent(string, []byte) error {
+	close(h.started)
+	<-h.release
+	return nil
+}
+
+func TestCloseWaitsForDeliveries(t *testing.T) {
+	handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	s, err := New(&Config{Listen: "127.0.0.1:0", Secret: "secret"}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"action":"opened"}`)
+	req, _ := http.NewRequest("POST", "http://"+s.listener.Addr().String()+defaultPath, bytes.NewReader(payload))
+	req.Header.Set(eventHeader, "issues")
+	req.Header.Set(signatureHeader, sign([]byte("secret"), payload))
+
+	status := make(chan int, 1)
+	go func() {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			status <- 0
+			return
+		}
+		res.Body.Close()
+		status <- res.StatusCode
+	}()
+	<-handler.started
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the delivery being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handler.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if code := <-status; code != http.StatusNoContent {
+		t.Fatalf("Expected in-flight delivery to complete with status %d but got %d\n", http.StatusNoContent, code)
+	}
+}